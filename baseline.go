@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// readBaseline reads a report saved with -output json=path.
+func readBaseline(path string) (loadtest.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return loadtest.Report{}, err
+	}
+	var baseline loadtest.Report
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return loadtest.Report{}, fmt.Errorf("invalid baseline report %s: %w", path, err)
+	}
+	if baseline.TotalRequests == 0 {
+		return loadtest.Report{}, fmt.Errorf("baseline report %s has no requests", path)
+	}
+	return baseline, nil
+}
+
+// compareBaseline prints a table comparing the main metrics of report with
+// baseline and returns a description of each regression beyond tolerance:
+// a relative increase of the response times or decrease of the request
+// rate by more than tolerance percent, or an error rate more than
+// tolerance percentage points higher.
+func compareBaseline(w io.Writer, baseline, report loadtest.Report, tolerance float64, style palette) []string {
+	var regressions []string
+
+	fmt.Fprintln(w, "\nComparison with baseline:")
+	fmt.Fprintf(w, "  %-22s %14s %14s %11s\n", "Metric", "Baseline", "Current", "Change")
+
+	latency := func(name string, before, after time.Duration) {
+		change := 0.0
+		if before > 0 {
+			change = float64(after-before) / float64(before) * 100
+		}
+		line := fmt.Sprintf("  %-22s %14v %14v %+10.2f%%", name, before, after, change)
+		if change > tolerance {
+			line = style.red(line)
+			regressions = append(regressions, fmt.Sprintf("%s %v is %.2f%% slower than the baseline %v", name, after, change, before))
+		}
+		fmt.Fprintln(w, line)
+	}
+	latency("p95 response time", baseline.P95, report.P95)
+	latency("average response time", baseline.AverageTime, report.AverageTime)
+
+	// Older reports have no error rate field.
+	baselineErrorRate := float64(baseline.TotalRequests-baseline.SuccessfulRequests) / float64(baseline.TotalRequests) * 100
+	change := report.ErrorRate - baselineErrorRate
+	line := fmt.Sprintf("  %-22s %13.2f%% %13.2f%% %+7.2f pts", "error rate", baselineErrorRate, report.ErrorRate, change)
+	if change > tolerance {
+		line = style.red(line)
+		regressions = append(regressions, fmt.Sprintf("error rate %.2f%% is %.2f points above the baseline %.2f%%", report.ErrorRate, change, baselineErrorRate))
+	}
+	fmt.Fprintln(w, line)
+
+	change = 0
+	if baseline.RequestsPerSecond > 0 {
+		change = (report.RequestsPerSecond - baseline.RequestsPerSecond) / baseline.RequestsPerSecond * 100
+	}
+	line = fmt.Sprintf("  %-22s %14.2f %14.2f %+10.2f%%", "requests per second", baseline.RequestsPerSecond, report.RequestsPerSecond, change)
+	if -change > tolerance {
+		line = style.red(line)
+		regressions = append(regressions, fmt.Sprintf("%.2f requests per second is %.2f%% below the baseline %.2f", report.RequestsPerSecond, -change, baseline.RequestsPerSecond))
+	}
+	fmt.Fprintln(w, line)
+
+	return regressions
+}
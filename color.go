@@ -0,0 +1,25 @@
+package main
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// palette colours parts of the text report. Its zero value leaves text
+// unchanged.
+type palette struct {
+	enabled bool
+}
+
+func (p palette) paint(color, s string) string {
+	if !p.enabled {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+func (p palette) red(s string) string    { return p.paint(ansiRed, s) }
+func (p palette) green(s string) string  { return p.paint(ansiGreen, s) }
+func (p palette) yellow(s string) string { return p.paint(ansiYellow, s) }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// alternativeFlags are pairs of flags that cannot be combined: giving one
+// on the command line overrides the other in the config file.
+var alternativeFlags = map[string]string{
+	"requests": "duration",
+	"duration": "requests",
+}
+
+// setFlags returns the names of the flags that have been set. Called
+// before applyConfigFile it reports the flags given on the command line;
+// afterwards it also reports the flags set by the file.
+func setFlags() map[string]bool {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// applyConfigFile sets flags from a JSON file whose keys are flag names,
+// e.g. {"url": "https://example.com", "requests": 1000, "H": ["Accept: */*"]}.
+// Flags in explicit, those given on the command line, keep their values.
+func applyConfigFile(path string, explicit map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	for name, value := range values {
+		if name == "config" {
+			return fmt.Errorf("config file %s cannot set \"config\"", path)
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+		if explicit[name] || explicit[alternativeFlags[name]] {
+			continue
+		}
+
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			if err := flag.Set(name, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid value for %q in config file %s: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
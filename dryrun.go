@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// printDryRun prints the first request config would send and the addresses
+// its host resolves to. It fails if the request cannot be built or the host
+// does not resolve.
+func printDryRun(config loadtest.Config) error {
+	req, err := loadtest.NewRequest(config)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+
+	fmt.Println("Dry run: no requests will be sent")
+	printLoadProfile(os.Stdout, config)
+	fmt.Println()
+
+	fmt.Printf("%s %s\n", req.Method, req.URL.Redacted())
+	fmt.Printf("Host: %s\n", req.Host)
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			fmt.Printf("%s: %s\n", name, redactHeader(name, value))
+		}
+	}
+	if config.BodyFile != "" {
+		req.Body.Close()
+		fmt.Printf("\nBody: streamed from %s (chunked)\n", config.BodyFile)
+	} else {
+		fmt.Printf("\nBody: %d bytes\n", req.ContentLength)
+	}
+
+	if config.UnixSocket != "" {
+		fmt.Printf("Unix socket: %s\n", config.UnixSocket)
+		printMoreTargets(config)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, req.URL.Hostname())
+	if err != nil {
+		return fmt.Errorf("could not resolve %s: %w", req.URL.Hostname(), err)
+	}
+	fmt.Printf("Resolved %s: %v\n", req.URL.Hostname(), addrs)
+	printMoreTargets(config)
+	return nil
+}
+
+// printLoadProfile prints how much load config generates and for how long,
+// as in the header printed when a test starts.
+func printLoadProfile(w io.Writer, config loadtest.Config) {
+	if len(config.Stages) > 0 {
+		stages := make([]string, len(config.Stages))
+		for i, stage := range config.Stages {
+			stages[i] = fmt.Sprintf("%v:%g", stage.Duration, stage.Rate)
+			if stage.Concurrency > 0 {
+				stages[i] += fmt.Sprintf(":%d", stage.Concurrency)
+			}
+		}
+		fmt.Fprintf(w, "Stages: %s\n", strings.Join(stages, ","))
+	} else if spike := config.Spike; spike != nil {
+		fmt.Fprintf(w, "Spike: ramp to %d over %v, hold %v, ramp down over %v\n", spike.Peak, spike.RampUp, spike.Hold, spike.RampDown)
+	} else if config.Duration > 0 {
+		fmt.Fprintf(w, "Duration: %v\n", config.Duration)
+	} else if len(config.Steps) > 0 {
+		fmt.Fprintf(w, "Total sessions: %d\n", config.Requests)
+	} else {
+		fmt.Fprintf(w, "Total requests: %d\n", config.Requests)
+	}
+	if config.Repeat > 1 {
+		fmt.Fprintf(w, "Runs: %d, cooldown %v\n", config.Repeat, config.Cooldown)
+	}
+	if adaptive := config.Adaptive; adaptive != nil {
+		fmt.Fprintf(w, "Adaptive: target p95 %v, adjusted every %v\n", adaptive.TargetP95, adaptive.Interval)
+	}
+	fmt.Fprintf(w, "Concurrency level: %d\n", config.Concurrency)
+}
+
+func printMoreTargets(config loadtest.Config) {
+	if len(config.Endpoints) > 1 {
+		fmt.Printf("\n%d more endpoints would be requested by weight\n", len(config.Endpoints)-1)
+	} else if len(config.URLs) > 1 {
+		fmt.Printf("\n%d more URLs would be requested in round-robin order\n", len(config.URLs)-1)
+	}
+}
+
+// redactHeader hides the credentials of authorization headers, keeping
+// their scheme, so that -token and -user values are not printed.
+func redactHeader(name, value string) string {
+	if name != "Authorization" && name != "Proxy-Authorization" {
+		return value
+	}
+	if scheme, _, ok := strings.Cut(value, " "); ok {
+		return scheme + " <redacted>"
+	}
+	return "<redacted>"
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestRedactHeader(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"Authorization", "Bearer s3cret", "Bearer <redacted>"},
+		{"Authorization", "Basic Ym9iOmh1bnRlcjI=", "Basic <redacted>"},
+		{"Proxy-Authorization", "s3cret", "<redacted>"},
+		{"Accept", "application/json", "application/json"},
+	}
+	for _, tt := range tests {
+		if got := redactHeader(tt.name, tt.value); got != tt.want {
+			t.Errorf("redactHeader(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var envReference = regexp.MustCompile(`\$\$|\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// expandEnv replaces each ${VAR} in s with the value of the environment
+// variable VAR, failing if it is not set, and each $$ with a literal $.
+func expandEnv(s string) (string, error) {
+	var missing string
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		name := ref[2 : len(ref)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
+
+// expandEnvValues expands the environment references of each value in
+// place.
+func expandEnvValues(values ...*string) error {
+	for _, value := range values {
+		expanded, err := expandEnv(*value)
+		if err != nil {
+			return err
+		}
+		*value = expanded
+	}
+	return nil
+}
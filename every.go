@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// monitor runs the test described by config every interval until ctx is
+// done. Each run's report goes to every output, summary lines prefixed with
+// the time the run ended, and rewrites the Prometheus file if one is set.
+// Thresholds are reported for each run but do not stop the loop. A run that
+// takes longer than interval delays the next one. Runs are not preflighted:
+// a target that is down is reported like any other failure.
+func monitor(ctx context.Context, config loadtest.Config, interval time.Duration, outputs []reportOutput, limits thresholds, prometheusFile string, noColor bool) error {
+	config.Preflight = false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := loadtest.Run(ctx, config)
+		if err != nil {
+			return err
+		}
+		report.Version = version
+
+		ended := time.Now().UTC().Format(time.RFC3339)
+		for _, output := range outputs {
+			style := palette{
+				enabled: output.file == os.Stdout && !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+			}
+			if output.format == "summary" {
+				fmt.Fprintf(output.file, "%s ", ended)
+			}
+			printReport(output.file, report, output.format, style, limits)
+		}
+		if prometheusFile != "" {
+			if err := writePrometheusFile(prometheusFile, report); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Could not write Prometheus metrics: %v\n", err)
+			}
+		}
+		for _, failure := range limits.check(report) {
+			fmt.Fprintf(os.Stderr, "%s Threshold failed: %s\n", ended, failure)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encodeForm builds a request body from -form key=value fields and
+// -form-file field=path uploads, returning it with its Content-Type. Without
+// files the body is application/x-www-form-urlencoded; otherwise it is
+// multipart/form-data. The body is encoded once and reused by every request.
+func encodeForm(fields, files []string) ([]byte, string, error) {
+	if len(files) == 0 {
+		values := make(url.Values)
+		for _, field := range fields {
+			key, value, err := splitFormValue(field, "-form")
+			if err != nil {
+				return nil, "", err
+			}
+			values.Add(key, value)
+		}
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, field := range fields {
+		key, value, err := splitFormValue(field, "-form")
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writer.WriteField(key, value); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, file := range files {
+		key, path, err := splitFormValue(file, "-form-file")
+		if err != nil {
+			return nil, "", err
+		}
+		if err := writeFormFile(writer, key, path); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
+
+func splitFormValue(value, name string) (string, string, error) {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid %s value %q, expected key=value", name, value)
+	}
+	return key, val, nil
+}
+
+func writeFormFile(writer *multipart.Writer, field, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read form file: %w", err)
+	}
+	defer f.Close()
+
+	part, err := writer.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
@@ -1,3 +1,3 @@
-module main
+module github.com/UmVitor/load-test-go
 
 go 1.21
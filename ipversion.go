@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// checkIPVersion fails unless every host config connects to, the proxy if
+// one is set, resolves to an address of config.IPVersion. Hosts of templated
+// URLs are only known once the requests are built and are not checked.
+func checkIPVersion(config loadtest.Config) error {
+	var hosts []string
+	if config.Proxy != nil {
+		hosts = append(hosts, config.Proxy.Hostname())
+	} else {
+		rawURLs := append([]string(nil), config.URLs...)
+		for _, endpoint := range config.Endpoints {
+			rawURLs = append(rawURLs, endpoint.URL)
+		}
+		for _, step := range config.Steps {
+			rawURLs = append(rawURLs, step.URL)
+		}
+		for _, rawURL := range rawURLs {
+			if strings.Contains(rawURL, "{{") {
+				continue
+			}
+			if u, err := url.Parse(rawURL); err == nil {
+				hosts = append(hosts, u.Hostname())
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	checked := make(map[string]bool)
+	for _, host := range hosts {
+		if checked[host] {
+			continue
+		}
+		checked[host] = true
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return fmt.Errorf("could not resolve %s: %w", host, err)
+		}
+		found := false
+		for _, ip := range ips {
+			if (ip.To4() != nil) == (config.IPVersion == 4) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s has no IPv%d address (it resolves to %v)", host, config.IPVersion, ips)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Adaptive adjusts the number of requests in flight to find the highest
+// throughput the target sustains within TargetP95. Starting from 1, the
+// concurrency doubles every Interval until an interval degrades, then grows
+// by Step, and halves whenever an interval degrades: when its p95 exceeds
+// TargetP95 or more than MaxErrorRate percent of its requests fail.
+// Config.Concurrency is the upper bound.
+type Adaptive struct {
+	TargetP95    time.Duration
+	Interval     time.Duration
+	MaxErrorRate float64
+	// Step defaults to 5% of Config.Concurrency, and at least 1.
+	Step int
+}
+
+func (a Adaptive) validate() error {
+	if a.TargetP95 <= 0 || a.Interval <= 0 || a.MaxErrorRate < 0 || a.MaxErrorRate >= 100 || a.Step < 0 {
+		return errors.New("loadtest: adaptive load needs a positive target p95 and interval, an error rate between 0 and 100 and a non-negative step")
+	}
+	return nil
+}
+
+// AdaptiveStats reports an Adaptive test: the load and response of each of
+// its intervals, and the concurrency at which the highest request rate was
+// achieved without degrading.
+type AdaptiveStats struct {
+	TargetP95       time.Duration      `json:"target_p95_ns"`
+	BestConcurrency int                `json:"best_concurrency"`
+	BestRPS         float64            `json:"best_rps"`
+	BestP95         time.Duration      `json:"best_p95_ns"`
+	Intervals       []AdaptiveInterval `json:"intervals"`
+}
+
+// AdaptiveInterval is one Adaptive.Interval of the test.
+type AdaptiveInterval struct {
+	Elapsed           time.Duration `json:"elapsed_ns"`
+	Concurrency       int           `json:"concurrency"`
+	Requests          int           `json:"requests"`
+	RequestsPerSecond float64       `json:"requests_per_second"`
+	ErrorRate         float64       `json:"error_rate"`
+	P95               time.Duration `json:"p95_ns"`
+	Degraded          bool          `json:"degraded"`
+}
+
+// adaptiveController sets the concurrency of an Adaptive test from the
+// results observed by the workers.
+type adaptiveController struct {
+	adaptive     Adaptive
+	max          int
+	successCodes StatusMatcher
+	current      int64
+
+	mu        sync.Mutex
+	durations []time.Duration
+	requests  int
+	failed    int
+	slowStart bool
+	stats     AdaptiveStats
+}
+
+func newAdaptiveController(config Config) *adaptiveController {
+	adaptive := *config.Adaptive
+	if adaptive.Step == 0 {
+		adaptive.Step = config.Concurrency / 20
+		if adaptive.Step < 1 {
+			adaptive.Step = 1
+		}
+	}
+	return &adaptiveController{
+		adaptive:     adaptive,
+		max:          config.Concurrency,
+		successCodes: config.SuccessCodes,
+		current:      1,
+		slowStart:    true,
+		stats:        AdaptiveStats{TargetP95: adaptive.TargetP95},
+	}
+}
+
+// limit is the number of requests currently allowed in flight.
+func (c *adaptiveController) limit() int {
+	return int(atomic.LoadInt64(&c.current))
+}
+
+func (c *adaptiveController) observe(result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if result.Error != nil || !c.successCodes.Match(result.StatusCode) {
+		c.failed++
+	}
+	if result.Error == nil {
+		c.durations = append(c.durations, result.Duration)
+	}
+}
+
+// run adjusts the concurrency every interval until ctx is done.
+func (c *adaptiveController) run(ctx context.Context, start time.Time) {
+	ticker := time.NewTicker(c.adaptive.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.adjust(now.Sub(start))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *adaptiveController) adjust(elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := c.limit()
+	interval := AdaptiveInterval{
+		Elapsed:           elapsed,
+		Concurrency:       current,
+		Requests:          c.requests,
+		RequestsPerSecond: float64(c.requests) / c.adaptive.Interval.Seconds(),
+	}
+	if c.requests > 0 {
+		interval.ErrorRate = float64(c.failed) / float64(c.requests) * 100
+	}
+	sort.Slice(c.durations, func(i, j int) bool { return c.durations[i] < c.durations[j] })
+	interval.P95 = percentile(c.durations, 95)
+	// An interval in which nothing completed is degraded too.
+	interval.Degraded = c.requests == 0 || interval.P95 > c.adaptive.TargetP95 || interval.ErrorRate > c.adaptive.MaxErrorRate
+	c.stats.Intervals = append(c.stats.Intervals, interval)
+	c.durations = c.durations[:0]
+	c.requests, c.failed = 0, 0
+
+	if !interval.Degraded && interval.RequestsPerSecond > c.stats.BestRPS {
+		c.stats.BestConcurrency = current
+		c.stats.BestRPS = interval.RequestsPerSecond
+		c.stats.BestP95 = interval.P95
+	}
+
+	switch {
+	case interval.Degraded:
+		c.slowStart = false
+		current /= 2
+	case c.slowStart:
+		current *= 2
+	default:
+		current += c.adaptive.Step
+	}
+	if current < 1 {
+		current = 1
+	}
+	if current > c.max {
+		current = c.max
+	}
+	atomic.StoreInt64(&c.current, int64(current))
+}
+
+func (c *adaptiveController) result() *AdaptiveStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Intervals = append([]AdaptiveInterval(nil), c.stats.Intervals...)
+	return &stats
+}
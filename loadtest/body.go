@@ -0,0 +1,108 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readBody drains resp.Body, decoding gzip and deflate content, and
+// returns both the decoded and the on-the-wire body sizes, plus the decoded
+// body itself if keep is set. The body is validated against
+// config.ExpectBody, config.ExpectRegex, config.ExpectJSON and
+// config.JSONSchema when set.
+func readBody(config Config, resp *http.Response, keep bool) (decoded, wire int64, data []byte, err error) {
+	wireReader := &countingReader{r: resp.Body}
+
+	var body io.Reader = wireReader
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		body, err = gzip.NewReader(wireReader)
+	case "deflate":
+		body, err = newDeflateReader(wireReader)
+	}
+	if errors.Is(err, io.EOF) {
+		// Responses such as HEAD or 304 carry the header without a body.
+		return 0, wireReader.n, nil, nil
+	}
+	if err != nil {
+		return 0, wireReader.n, nil, err
+	}
+
+	if config.ExpectBody == "" && config.ExpectRegex == nil && !config.ExpectJSON && config.JSONSchema == nil && !keep {
+		decoded, err = io.Copy(io.Discard, body)
+		return decoded, wireReader.n, nil, err
+	}
+
+	data, err = io.ReadAll(body)
+	if err == nil {
+		err = validateBody(config, data)
+	}
+	return int64(len(data)), wireReader.n, data, err
+}
+
+// newDeflateReader decodes a deflate body. HTTP defines deflate as
+// zlib-wrapped, but some servers send a raw deflate stream, so the zlib
+// header is only expected when the first two bytes carry one.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil && len(header) == 0 {
+		return nil, err
+	}
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+type bodyValidationError struct {
+	reason string
+	// schema is set when the body is JSON that does not match
+	// Config.JSONSchema.
+	schema bool
+}
+
+func (e *bodyValidationError) Error() string {
+	return "body validation failed: " + e.reason
+}
+
+func validateBody(config Config, body []byte) error {
+	if config.ExpectBody != "" && !bytes.Contains(body, []byte(config.ExpectBody)) {
+		return &bodyValidationError{reason: fmt.Sprintf("body does not contain %q", config.ExpectBody)}
+	}
+	if config.ExpectRegex != nil && !config.ExpectRegex.Match(body) {
+		return &bodyValidationError{reason: fmt.Sprintf("body does not match %q", config.ExpectRegex)}
+	}
+	if config.ExpectJSON || config.JSONSchema != nil {
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return &bodyValidationError{reason: fmt.Sprintf("body is not valid JSON: %v", err)}
+		}
+		if config.JSONSchema != nil {
+			if err := config.JSONSchema.validate(value, "$"); err != nil {
+				return &bodyValidationError{reason: err.Error(), schema: true}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package loadtest
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadBodyDeflate(t *testing.T) {
+	want := bytes.Repeat([]byte("deflate "), 512)
+	tests := []struct {
+		name   string
+		writer func(io.Writer) io.WriteCloser
+	}{
+		{"zlib", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"raw", func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoded bytes.Buffer
+			zw := tt.writer(&encoded)
+			zw.Write(want)
+			zw.Close()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "deflate")
+				w.Write(encoded.Bytes())
+			}))
+			defer server.Close()
+
+			report := run(t, Config{
+				URLs:        []string{server.URL},
+				Concurrency: 1,
+				Requests:    2,
+				ExpectBody:  "deflate deflate",
+			})
+
+			if report.SuccessfulRequests != 2 {
+				t.Fatalf("got %d successful requests, want 2; errors %v",
+					report.SuccessfulRequests, report.ErrorCategories)
+			}
+			if report.TotalBytes != 2*int64(len(want)) || report.TotalWireBytes != 2*int64(encoded.Len()) {
+				t.Errorf("got %d bytes and %d on the wire, want %d and %d",
+					report.TotalBytes, report.TotalWireBytes, 2*len(want), 2*encoded.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// dnsCache resolves each host once and reuses its addresses for every later
+// connection, keeping resolver latency out of the measured requests.
+type dnsCache struct {
+	dialer *net.Dialer
+	// network is "tcp", or "tcp4" or "tcp6" to resolve and dial only
+	// addresses of that family.
+	network string
+	verbose io.Writer
+
+	mu    sync.Mutex
+	hosts map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func newDNSCache(dialer *net.Dialer, network string, verbose io.Writer) *dnsCache {
+	return &dnsCache{dialer: dialer, network: network, verbose: verbose, hosts: make(map[string]*dnsEntry)}
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry := c.hosts[host]
+	if entry == nil {
+		entry = &dnsEntry{}
+		c.hosts[host] = entry
+	}
+	c.mu.Unlock()
+
+	// Failed lookups are not cached, so a later connection retries them.
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.addrs == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+strings.TrimPrefix(c.network, "tcp"), host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = ip.String()
+		}
+		entry.addrs = addrs
+		if c.verbose != nil {
+			fmt.Fprintf(c.verbose, "Resolved %s to %v\n", host, addrs)
+		}
+	}
+	return entry.addrs, nil
+}
+
+// DialContext dials addr using the cached addresses of its host, trying
+// each in turn.
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		network = c.network
+	}
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
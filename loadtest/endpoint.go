@@ -0,0 +1,194 @@
+package loadtest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Endpoint is one request of a weighted scenario. Endpoints are chosen at
+// random for each request with probability proportional to their Weight.
+type Endpoint struct {
+	// Name identifies the endpoint in the report; it defaults to
+	// "METHOD URL".
+	Name   string
+	Method string
+	// URL may contain template actions, as in Config.URLs.
+	URL  string
+	Body []byte
+	// Headers are added to Config.Headers, replacing any with the same name.
+	Headers http.Header
+	// Weight defaults to 1 when 0.
+	Weight int
+}
+
+// target is a request to send, built from either Config.URLs or
+// Config.Endpoints.
+type target struct {
+	name    string
+	method  string
+	url     urlTemplate
+	body    []byte
+	headers http.Header
+	weight  int
+
+	// steps and vars are only set for the steps of a session.
+	steps *stepTemplates
+	vars  map[string]string
+}
+
+func newTargets(config Config) ([]target, error) {
+	if len(config.Steps) > 0 {
+		return newStepTargets(config)
+	}
+	if len(config.Endpoints) == 0 {
+		templates, err := parseURLTemplates(config.URLs)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]target, len(templates))
+		for i, tmpl := range templates {
+			targets[i] = target{
+				name:    tmpl.raw,
+				method:  config.Method,
+				url:     tmpl,
+				body:    config.Body,
+				headers: config.Headers,
+			}
+		}
+		return targets, nil
+	}
+
+	urls := make([]string, len(config.Endpoints))
+	for i, endpoint := range config.Endpoints {
+		urls[i] = endpoint.URL
+	}
+	templates, err := parseURLTemplates(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]target, len(config.Endpoints))
+	for i, endpoint := range config.Endpoints {
+		if endpoint.Weight < 0 {
+			return nil, fmt.Errorf("loadtest: endpoint %q has a negative weight", endpoint.URL)
+		}
+		t := target{
+			name:    endpoint.Name,
+			method:  endpoint.Method,
+			url:     templates[i],
+			body:    endpoint.Body,
+			headers: config.Headers.Clone(),
+			weight:  endpoint.Weight,
+		}
+		if t.method == "" {
+			t.method = http.MethodGet
+		}
+		if t.name == "" {
+			t.name = t.method + " " + endpoint.URL
+		}
+		if t.weight == 0 {
+			t.weight = 1
+		}
+		if t.headers == nil {
+			t.headers = make(http.Header)
+		}
+		for name, values := range endpoint.Headers {
+			t.headers[http.CanonicalHeaderKey(name)] = values
+		}
+		targets[i] = t
+	}
+	return targets, nil
+}
+
+// picker chooses the target for each request: weighted random for
+// Config.Endpoints, and for Config.URLs round-robin or random as set by
+// Config.URLOrder. It also draws jittered intervals between requests from
+// the same seeded source.
+type picker struct {
+	targets []target
+	weights []int // cumulative; nil for round-robin
+	total   int
+	// bodies replace the body of each picked target in turn.
+	bodies [][]byte
+	next   uint64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newPicker(config Config, targets []target) *picker {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	p := &picker{targets: targets, bodies: config.Bodies, rand: rand.New(rand.NewSource(seed))}
+
+	switch {
+	case len(config.Endpoints) > 0:
+	case len(config.Steps) == 0 && config.URLOrder == "weighted":
+		for i := range targets {
+			targets[i].weight = 1
+		}
+	case len(config.Steps) == 0 && config.URLOrder == "shuffle":
+		// Repeated URLs count once.
+		seen := make(map[string]bool)
+		for i := range targets {
+			if !seen[targets[i].name] {
+				seen[targets[i].name] = true
+				targets[i].weight = 1
+			}
+		}
+	default:
+		return p
+	}
+
+	p.weights = make([]int, len(targets))
+	for i, t := range targets {
+		p.total += t.weight
+		p.weights[i] = p.total
+	}
+	return p
+}
+
+func (p *picker) pick(i int) target {
+	t := p.choose(i)
+	if len(p.bodies) > 0 {
+		n := atomic.AddUint64(&p.next, 1) - 1
+		t.body = p.bodies[n%uint64(len(p.bodies))]
+	}
+	return t
+}
+
+func (p *picker) choose(i int) target {
+	if p.weights == nil {
+		return p.targets[i%len(p.targets)]
+	}
+	p.mu.Lock()
+	n := p.rand.Intn(p.total)
+	p.mu.Unlock()
+	for j, w := range p.weights {
+		if n < w {
+			return p.targets[j]
+		}
+	}
+	return p.targets[len(p.targets)-1]
+}
+
+// nextInterval returns the time until the next request at config.Rate,
+// randomized as set by config.RateJitter or config.PoissonArrivals.
+func (p *picker) nextInterval(config Config) time.Duration {
+	interval := float64(time.Second) / config.Rate
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case config.PoissonArrivals:
+		interval *= p.rand.ExpFloat64()
+	case config.RateJitter > 0:
+		interval *= 1 + config.RateJitter*(2*p.rand.Float64()-1)
+	}
+	return time.Duration(interval)
+}
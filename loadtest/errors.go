@@ -0,0 +1,49 @@
+package loadtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// errTTFBTimeout fails requests whose first response byte does not arrive
+// within Config.TTFBTimeout.
+var errTTFBTimeout = errors.New("no response received")
+
+// ErrPreflight is returned by Run, wrapping the request's error, when the
+// Config.Preflight request fails in a way every request would.
+var ErrPreflight = errors.New("loadtest: preflight request failed")
+
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var bodyErr *bodyValidationError
+
+	switch {
+	case errors.As(err, &bodyErr):
+		return "body validation"
+	case errors.Is(err, errTTFBTimeout):
+		return "ttfb timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return "tls"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,1052 @@
+// Package loadtest sends HTTP requests against one or more URLs and
+// aggregates the results into a Report.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config describes a load test run.
+type Config struct {
+	// URLs are requested in round-robin order. They may contain template
+	// actions such as {{uuid}}, expanded per request (see templateFuncs).
+	URLs []string
+	// URLOrder is how requests choose among URLs: round-robin when empty,
+	// "shuffle" to pick one of the distinct URLs at random, or "weighted"
+	// to pick a random entry so that repeated URLs are chosen more often.
+	URLOrder string
+	// Seed seeds the random choice of URLs and endpoints, making it
+	// reproducible; 0 picks a random seed.
+	Seed int64
+	// Endpoints, when set, replace URLs, Method and Body with a weighted
+	// scenario.
+	Endpoints []Endpoint
+	// Steps, when set, replace URLs, Method and Body with a session that
+	// each of Concurrency virtual users runs in order. Requests then counts
+	// sessions rather than requests.
+	Steps  []Step
+	Method string
+	Body   []byte
+	// Bodies, when set, replace Body with one body per request, used in
+	// order across the whole run and cycling when there are more requests.
+	Bodies [][]byte
+	// BodyFile, when set, is a file that every request streams as its body
+	// with chunked transfer encoding, reading it through a handle of its
+	// own instead of holding it in memory. It replaces Body and cannot be
+	// combined with Endpoints, Steps or Bodies.
+	BodyFile string
+	Headers  http.Header
+	// RandomHeaders sets each of its headers on every request to one of its
+	// values picked at random, overriding Headers.
+	RandomHeaders http.Header
+	Username      string
+	Password      string
+	// Host, when set, overrides the Host header and TLS server name of every
+	// request, while connections still go to the URL's address.
+	Host string
+
+	// Requests is the total number of requests to send. It is ignored when
+	// Duration is set.
+	Requests    int
+	Concurrency int
+	Timeout     time.Duration
+	Duration    time.Duration
+	// TTFBTimeout, when set, fails a request whose first response byte has
+	// not arrived within it, independently of Timeout, which also covers
+	// reading the body.
+	TTFBTimeout time.Duration
+	// Stages, when set, replace Requests and Duration with a sequence of
+	// load levels run one after another.
+	Stages []Stage
+	// Spike, when set, replaces Requests, Duration, Concurrency and RampUp
+	// with a profile that ramps the requests in flight up and back down.
+	Spike *Spike
+	// Adaptive, when set, varies the requests in flight between 1 and
+	// Concurrency to find the highest throughput within a target p95.
+	Adaptive *Adaptive
+	// StreamingQuantiles estimates the percentiles and median from a
+	// fixed-size sketch, within 1% of their exact value, instead of keeping
+	// every response time. Memory then stays constant however many
+	// requests are sent.
+	StreamingQuantiles bool
+	// TopSlow is the number of slowest requests kept as
+	// Report.SlowestRequests.
+	TopSlow int
+	// SLO, when set, is the response time above which a completed request
+	// counts as a Report.SLOViolations, whatever its status code.
+	SLO time.Duration
+	// KneeErrorRate, when set, is the rolling error rate in percent whose
+	// first crossing is recorded as Report.KneePoint.
+	KneeErrorRate float64
+	// MaxDuration, when set, is a hard deadline for the whole run, warmup
+	// included but not the preflight request. Reaching it cancels in-flight requests and sets
+	// Report.DeadlineExceeded.
+	MaxDuration time.Duration
+	// Repeat, when greater than 1, runs the whole test that many times,
+	// waiting Cooldown between runs. The Report covers every run, plus a
+	// breakdown by run, and its rates exclude the cooldowns.
+	Repeat   int
+	Cooldown time.Duration
+	// DrainTimeout, when set, lets the requests in flight when ctx is
+	// cancelled finish for up to this long, instead of cancelling them at
+	// once, so that the partial report only counts completed work.
+	DrainTimeout time.Duration
+	// Rate caps the number of requests per second; 0 means unlimited.
+	Rate float64
+	// RateJitter randomizes each interval between requests at Rate by up to
+	// this fraction either way, and PoissonArrivals draws the intervals
+	// from an exponential distribution instead. Both keep the long-run
+	// average at Rate while avoiding synchronized bursts.
+	RateJitter      float64
+	PoissonArrivals bool
+	// OpenModel starts requests at the arrival rate set by Rate whether or
+	// not earlier ones have completed, instead of running Concurrency
+	// workers. Concurrency then caps the requests in flight.
+	OpenModel bool
+	// MaxInFlight, when set, caps the requests in flight across every
+	// dispatch mode, protecting the load generator from running out of
+	// memory or file descriptors. A request over the cap waits for a slot,
+	// or is dropped without being sent when DropAtMaxInFlight is set. Only
+	// the open model can drop requests: a closed-model worker whose request
+	// was dropped would send its next one at once.
+	MaxInFlight       int
+	DropAtMaxInFlight bool
+	// SuccessCodes defaults to any 2xx status when empty.
+	SuccessCodes StatusMatcher
+
+	// Progress, when set, receives a live progress line while the test runs.
+	Progress io.Writer
+	// Label and Tags annotate the Report, and the CSV rows, so that stored
+	// results describe the run, e.g. its environment or git revision.
+	Label string
+	Tags  map[string]string
+	// CSV, when set, receives one row per request.
+	CSV *csv.Writer
+	// Interim, when set together with Interval, receives a one-line
+	// summary of the requests completed in each interval.
+	Interim  io.Writer
+	Interval time.Duration
+	// TimeSeriesInterval, when set, breaks the results down into
+	// consecutive intervals of this length in Report.TimeSeries, by the
+	// time each was received.
+	TimeSeriesInterval time.Duration
+	// Verbose, when set, receives a log line for each of the first
+	// maxVerboseRequests requests, including response headers if
+	// VerboseHeaders is set.
+	Verbose        io.Writer
+	VerboseHeaders bool
+	// TrackHeaders names response headers whose values are tallied in
+	// Report.HeaderValues, such as X-Cache to see the cache hit rate.
+	TrackHeaders []string
+
+	Warmup int
+	// Preflight sends a single request before the test, counted as a warmup
+	// request, and makes Run fail with ErrPreflight without starting the
+	// test if its host does not resolve, its connection is refused or its
+	// TLS handshake fails.
+	Preflight bool
+	// Retries is the maximum number of times a request is retried after a
+	// transport error, a 429 response, or a 5xx response when
+	// RetryServerErrors is set. Each retry waits RetryBackoff, doubling
+	// after every attempt, unless a 429 or 503 response has a Retry-After
+	// header, which is waited for instead (and makes a 503 retried anyway).
+	Retries           int
+	RetryBackoff      time.Duration
+	RetryServerErrors bool
+	// StopOnError ends the run at the first request that errors or gets a
+	// status outside SuccessCodes, setting Report.StoppedOnError.
+	StopOnError bool
+	// ThinkTime is how long a worker pauses after each request before its
+	// next one, randomised by up to ±ThinkJitter.
+	ThinkTime   time.Duration
+	ThinkJitter time.Duration
+	// Stagger delays the first request of each of the Concurrency workers
+	// by a random offset of up to this long, so that they do not all start
+	// at once and keep sending in step. It has no effect in the open model,
+	// whose requests already arrive on a schedule.
+	Stagger          time.Duration
+	DisableKeepAlive bool
+	// DisableDNSCache resolves hosts on every new connection instead of once
+	// per run.
+	DisableDNSCache bool
+	// IPVersion, when 4 or 6, restricts connections to IPv4 or IPv6
+	// addresses.
+	IPVersion int
+	// UnixSocket, when set, is the path of a Unix domain socket that every
+	// connection dials instead of the URL's host, which then only sets the
+	// Host header. Proxies are not used.
+	UnixSocket string
+	// Cookies enables a cookie jar shared by all requests, so cookies set
+	// by one response are sent on later requests.
+	Cookies bool
+	// Proxy routes all requests through the given http, https or socks5
+	// proxy. Without it, the HTTP_PROXY family of environment variables is
+	// only honoured when ProxyFromEnvironment is set.
+	Proxy                *url.URL
+	ProxyFromEnvironment bool
+	Insecure             bool
+	// Certificates are presented to servers that request a client
+	// certificate. RootCAs, when set, replaces the system root pool.
+	Certificates []tls.Certificate
+	RootCAs      *x509.CertPool
+	// TLSMinVersion and TLSMaxVersion, when set, bound the TLS versions
+	// offered, e.g. tls.VersionTLS12 for both to test TLS 1.2 only.
+	// CipherSuites, when set, restricts the TLS 1.2 and earlier cipher
+	// suites; the TLS 1.3 ones are not configurable.
+	TLSMinVersion uint16
+	TLSMaxVersion uint16
+	CipherSuites  []uint16
+	// MaxRedirects is the number of redirects to follow before the redirect
+	// response itself is recorded.
+	MaxRedirects int
+	RampUp       time.Duration
+	// ExpectBody and ExpectRegex, when set, fail any response whose body
+	// does not contain the substring or match the expression.
+	ExpectBody  string
+	ExpectRegex *regexp.Regexp
+	// ExpectJSON fails any response whose body is not valid JSON, and
+	// JSONSchema, which implies it, any whose body does not match the
+	// schema.
+	ExpectJSON bool
+	JSONSchema *Schema
+	// SkipBody closes each response as soon as its headers arrive instead
+	// of reading the body. Durations then end at the headers and byte
+	// counts are zero, and, as an HTTP/1.1 connection is only reused when
+	// the whole body had already arrived, responses with large bodies
+	// mostly need a new connection. It cannot be combined with body
+	// validation or step extractions.
+	SkipBody bool
+	// Buckets are the upper bounds of the latency histogram, in increasing
+	// order. DefaultBuckets is used when empty.
+	Buckets []time.Duration
+	// HTTPVersion forces "1.1" or "2"; empty negotiates as usual. Forcing
+	// HTTP/2 fails any request whose response uses another protocol.
+	HTTPVersion string
+
+	// inflight holds a slot for each request in flight when MaxInFlight
+	// is set.
+	inflight chan struct{}
+	// adaptive sets the concurrency when Adaptive is set.
+	adaptive *adaptiveController
+}
+
+// Result is the outcome of a single request.
+type Result struct {
+	Method string
+	URL    string
+	// Endpoint is the Endpoint name, or the URL when Config.URLs is used.
+	Endpoint string
+	// Stage is the index of the Config.Stages entry the request belongs to,
+	// and Run the index of its repetition when Config.Repeat is set.
+	Stage      int
+	Run        int
+	Start      time.Time
+	StatusCode int
+	Proto      string
+	Duration   time.Duration
+	Timing     Timing
+	Bytes      int64
+	WireBytes  int64
+	Header     http.Header
+	// Retries is the number of attempts made before this final one.
+	Retries int
+	// RateLimited is set when any attempt got a 429 response, or a 503 with
+	// a Retry-After header.
+	RateLimited bool
+	// QueueTime is how long an open model request waited past its scheduled
+	// start, or any request waited for Config.MaxInFlight, for a free slot,
+	// and InFlight the number of requests in flight when it started, itself
+	// included.
+	QueueTime time.Duration
+	InFlight  int
+	// Dropped is set when the request was not sent because
+	// Config.MaxInFlight requests were in flight.
+	Dropped bool
+	Error   error
+
+	// body is the response body of a session step with extractions.
+	body []byte
+	// retryAfter is the wait requested by a Retry-After header.
+	retryAfter time.Duration
+}
+
+// Run executes the load test described by config and returns the aggregated
+// report. Cancelling ctx stops the test early; the report then covers the
+// requests completed so far and has Interrupted set.
+func Run(ctx context.Context, config Config) (Report, error) {
+	if len(config.URLs) == 0 && len(config.Endpoints) == 0 && len(config.Steps) == 0 {
+		return Report{}, errors.New("loadtest: no URLs configured")
+	}
+	if config.Concurrency <= 0 {
+		return Report{}, errors.New("loadtest: concurrency must be greater than 0")
+	}
+	if config.Duration <= 0 && config.Requests <= 0 && len(config.Stages) == 0 {
+		return Report{}, errors.New("loadtest: either requests or duration must be greater than 0")
+	}
+	for _, stage := range config.Stages {
+		if stage.Duration <= 0 || stage.Rate < 0 || stage.Concurrency < 0 {
+			return Report{}, errors.New("loadtest: stages need a positive duration and a non-negative rate and concurrency")
+		}
+		if config.OpenModel && stageConfig(config, stage).Rate <= 0 {
+			return Report{}, errors.New("loadtest: the open model requires a rate for every stage")
+		}
+	}
+	if config.Spike != nil {
+		if err := config.Spike.validate(); err != nil {
+			return Report{}, err
+		}
+		if len(config.Stages) > 0 || len(config.Steps) > 0 || config.OpenModel {
+			return Report{}, errors.New("loadtest: a spike cannot be combined with stages, steps or the open model")
+		}
+		config.Concurrency = config.Spike.Peak
+		config.Duration = config.Spike.duration()
+		config.Requests = 0
+		config.RampUp = 0
+	}
+	if config.Adaptive != nil {
+		if err := config.Adaptive.validate(); err != nil {
+			return Report{}, err
+		}
+		if config.Spike != nil || len(config.Stages) > 0 || len(config.Steps) > 0 || config.OpenModel || config.RampUp > 0 || config.Rate > 0 || config.Repeat > 1 {
+			return Report{}, errors.New("loadtest: adaptive load cannot be combined with a spike, stages, steps, the open model, a ramp-up, a rate or repeats")
+		}
+	}
+	for name, values := range config.RandomHeaders {
+		if len(values) == 0 {
+			return Report{}, fmt.Errorf("loadtest: random header %s has no values", name)
+		}
+	}
+	if config.IPVersion != 0 && config.IPVersion != 4 && config.IPVersion != 6 {
+		return Report{}, fmt.Errorf("loadtest: invalid IP version %d", config.IPVersion)
+	}
+	if config.SkipBody {
+		if config.ExpectBody != "" || config.ExpectRegex != nil || config.ExpectJSON || config.JSONSchema != nil {
+			return Report{}, errors.New("loadtest: response bodies must be read to be validated")
+		}
+		for _, step := range config.Steps {
+			if len(step.Extract) > 0 {
+				return Report{}, errors.New("loadtest: response bodies must be read for step extractions")
+			}
+		}
+	}
+	if config.BodyFile != "" && (len(config.Body) > 0 || len(config.Bodies) > 0 || len(config.Endpoints) > 0 || len(config.Steps) > 0) {
+		return Report{}, errors.New("loadtest: a streamed body file cannot be combined with other bodies, endpoints or steps")
+	}
+	if len(config.Bodies) > 0 && len(config.Steps) > 0 {
+		return Report{}, errors.New("loadtest: per-request bodies cannot be combined with steps")
+	}
+	if config.Repeat < 0 || config.Cooldown < 0 {
+		return Report{}, errors.New("loadtest: the repeat count and cooldown must not be negative")
+	}
+	if config.RateJitter < 0 || config.RateJitter > 1 {
+		return Report{}, errors.New("loadtest: the rate jitter must be between 0 and 1")
+	}
+	if config.MaxInFlight < 0 {
+		return Report{}, errors.New("loadtest: the maximum in flight must not be negative")
+	}
+	if config.DropAtMaxInFlight && (config.MaxInFlight == 0 || !config.OpenModel) {
+		return Report{}, errors.New("loadtest: dropping requests requires a maximum in flight and the open model")
+	}
+	if config.MaxInFlight > 0 {
+		config.inflight = make(chan struct{}, config.MaxInFlight)
+	}
+	if config.OpenModel && config.Rate <= 0 && len(config.Stages) == 0 {
+		return Report{}, errors.New("loadtest: the open model requires a rate")
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultBuckets
+	}
+	if len(config.SuccessCodes) == 0 {
+		config.SuccessCodes = StatusMatcher{{Min: 200, Max: 299}}
+	}
+
+	switch config.URLOrder {
+	case "", "shuffle", "weighted":
+	default:
+		return Report{}, fmt.Errorf("loadtest: unknown URL order %q", config.URLOrder)
+	}
+
+	targets, err := newTargets(config)
+	if err != nil {
+		return Report{}, err
+	}
+	picker := newPicker(config, targets)
+
+	client := newClient(config)
+
+	// The preflight request runs before MaxDuration starts, so that a slow
+	// but reachable target is not reported as unreachable.
+	warmupRequests := 0
+	if config.Preflight {
+		if err := preflight(ctx, client, config, picker); err != nil {
+			return Report{}, err
+		}
+		warmupRequests++
+	}
+
+	deadlineCtx := ctx
+	if config.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		deadlineCtx, cancel = context.WithTimeout(ctx, config.MaxDuration)
+		defer cancel()
+	}
+	runCtx, stop := context.WithCancel(deadlineCtx)
+	defer stop()
+	requestCtx := runCtx
+	if config.DrainTimeout > 0 {
+		var cancelRequests context.CancelFunc
+		requestCtx, cancelRequests = drainContext(ctx, runCtx, config.DrainTimeout)
+		defer cancelRequests()
+	}
+
+	if config.Warmup > 0 {
+		warmup := config
+		if len(config.Stages) > 0 {
+			// Warm up at the first stage's load level, whose rate the open
+			// model needs when Rate itself is unset.
+			warmup = stageConfig(config, config.Stages[0])
+		}
+		warmup.Requests = config.Warmup
+		warmup.Duration = 0
+		warmup.RampUp = 0
+		warmup.Spike = nil
+		warmup.ThinkTime = 0
+		warmup.ThinkJitter = 0
+		warmup.Stagger = 0
+		for range dispatch(runCtx, requestCtx, client, warmup, picker) {
+			warmupRequests++
+		}
+	}
+
+	var results dispatchFunc = dispatch
+	if len(config.Stages) > 0 {
+		results = dispatchStages
+		// The total duration is only used to show progress.
+		config.Duration = 0
+		for _, stage := range config.Stages {
+			config.Duration += stage.Duration
+		}
+	}
+
+	collectConfig := config
+	if config.Repeat > 1 {
+		results = repeated(results)
+		// The totals are only used to show progress.
+		collectConfig.Requests *= config.Repeat
+		collectConfig.Duration *= time.Duration(config.Repeat)
+	}
+
+	startTime := time.Now()
+	if config.Adaptive != nil {
+		config.adaptive = newAdaptiveController(config)
+		collectConfig.adaptive = config.adaptive
+		go config.adaptive.run(runCtx, startTime)
+	}
+	report := collect(collectConfig, results(runCtx, requestCtx, client, config, picker), startTime, stop)
+	if config.adaptive != nil {
+		report.Adaptive = config.adaptive.result()
+	}
+	report.WarmupRequests = warmupRequests
+	report.Interrupted = ctx.Err() != nil
+	report.DeadlineExceeded = !report.Interrupted && deadlineCtx.Err() != nil
+
+	return report, nil
+}
+
+// drainContext returns the context for requests, which is canceled with
+// runCtx except that, when the caller's ctx is cancelled, the requests in
+// flight get up to timeout to finish first.
+func drainContext(ctx, runCtx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	requestCtx, cancel := context.WithCancel(context.WithoutCancel(runCtx))
+	go func() {
+		select {
+		case <-runCtx.Done():
+		case <-requestCtx.Done():
+			return
+		}
+		if ctx.Err() != nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-requestCtx.Done():
+			}
+		}
+		cancel()
+	}()
+	return requestCtx, cancel
+}
+
+// dialNetwork returns the network connections are dialed on: "tcp4" or
+// "tcp6" for Config.IPVersion, or "tcp" for either.
+func dialNetwork(config Config) string {
+	switch config.IPVersion {
+	case 4:
+		return "tcp4"
+	case 6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+func newClient(config Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxConcurrency(config)
+	transport.MaxIdleConnsPerHost = transport.MaxIdleConns
+	transport.DisableKeepAlives = config.DisableKeepAlive
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	switch {
+	case config.UnixSocket != "":
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", config.UnixSocket)
+		}
+	case !config.DisableDNSCache:
+		transport.DialContext = newDNSCache(dialer, dialNetwork(config), config.Verbose).DialContext
+	case config.IPVersion != 0:
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, dialNetwork(config), addr)
+		}
+	}
+	switch {
+	case config.UnixSocket != "":
+		transport.Proxy = nil
+	case config.Proxy != nil:
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	case config.ProxyFromEnvironment:
+		transport.Proxy = http.ProxyFromEnvironment
+	default:
+		transport.Proxy = nil
+	}
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: config.Insecure,
+		Certificates:       config.Certificates,
+		RootCAs:            config.RootCAs,
+		MinVersion:         config.TLSMinVersion,
+		MaxVersion:         config.TLSMaxVersion,
+		CipherSuites:       config.CipherSuites,
+	}
+	if config.Host != "" {
+		serverName := config.Host
+		if host, _, err := net.SplitHostPort(serverName); err == nil {
+			serverName = host
+		}
+		transport.TLSClientConfig.ServerName = serverName
+	}
+	switch config.HTTPVersion {
+	case "1.1":
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	case "2":
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	var jar http.CookieJar
+	if config.Cookies {
+		// cookiejar.New only fails for invalid options.
+		jar, _ = cookiejar.New(nil)
+	}
+
+	return &http.Client{
+		Jar:       jar,
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// dispatch sends the requests of config until they are all sent or ctx is
+// done. The requests themselves run with requestCtx, which outlives ctx
+// while in-flight requests drain.
+func dispatch(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result {
+	if len(config.Steps) > 0 {
+		return dispatchSessions(ctx, requestCtx, client, config, picker)
+	}
+	if config.OpenModel {
+		return dispatchOpen(ctx, requestCtx, client, config, picker)
+	}
+
+	resultChan := make(chan Result, config.Concurrency)
+
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, config.Concurrency)
+
+	start := time.Now()
+	deadline := start.Add(config.Duration)
+
+	go func() {
+		var limiter <-chan time.Time
+		if config.Rate > 0 && (config.RateJitter > 0 || config.PoissonArrivals) {
+			tickCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			limiter = jitteredTicker(tickCtx, config, picker)
+		} else if config.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Rate))
+			defer ticker.Stop()
+			limiter = ticker.C
+		}
+
+		for i := 0; config.Duration > 0 || i < config.Requests; i++ {
+			if config.RampUp > 0 || config.Spike != nil || config.adaptive != nil {
+				waitForRampUp(ctx, semaphore, config, start)
+			}
+
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			if config.Duration > 0 && !time.Now().Before(deadline) {
+				<-semaphore
+				break
+			}
+
+			target := picker.pick(i)
+			first := i < config.Concurrency
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				if first && config.Stagger > 0 {
+					sleep(ctx, staggerDelay(config))
+					if config.Duration > 0 && !time.Now().Before(deadline) {
+						return
+					}
+				}
+				if limiter != nil {
+					select {
+					case <-limiter:
+					case <-ctx.Done():
+					}
+					if config.Duration > 0 && !time.Now().Before(deadline) {
+						return
+					}
+				}
+
+				if ctx.Err() != nil {
+					return
+				}
+				result := sendRequest(requestCtx, client, config, target)
+				if config.adaptive != nil && !result.Dropped {
+					config.adaptive.observe(result)
+				}
+				resultChan <- result
+
+				if config.ThinkTime > 0 || config.ThinkJitter > 0 {
+					sleep(ctx, thinkTime(config))
+				}
+			}()
+		}
+
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	return resultChan
+}
+
+// dispatchOpen starts a request every 1/config.Rate seconds. A request that
+// is due while config.Concurrency requests are in flight waits for one of
+// them to complete; the wait is recorded as its QueueTime, and the requests
+// behind it keep their original schedule.
+func dispatchOpen(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result {
+	resultChan := make(chan Result, config.Concurrency)
+
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, config.Concurrency)
+
+	start := time.Now()
+	deadline := start.Add(config.Duration)
+
+	go func() {
+		scheduled := start
+		for i := 0; config.Duration > 0 || i < config.Requests; i++ {
+			if i > 0 {
+				scheduled = scheduled.Add(picker.nextInterval(config))
+			}
+			if config.Duration > 0 && !scheduled.Before(deadline) {
+				break
+			}
+			sleep(ctx, time.Until(scheduled))
+
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			if config.Duration > 0 && !time.Now().Before(deadline) {
+				<-semaphore
+				break
+			}
+			queueTime := time.Since(scheduled)
+			inFlight := len(semaphore)
+
+			target := picker.pick(i)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+
+				result := sendRequest(requestCtx, client, config, target)
+				result.QueueTime += queueTime
+				result.InFlight = inFlight
+				resultChan <- result
+			}()
+		}
+
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	return resultChan
+}
+
+// jitteredTicker delivers ticks like a time.Ticker at intervals drawn from
+// picker.nextInterval until ctx is done. Up to config.Concurrency ticks are
+// buffered so that the short intervals of a burst are not lost; further
+// ticks are dropped while the receivers fall behind.
+func jitteredTicker(ctx context.Context, config Config, picker *picker) <-chan time.Time {
+	ticks := make(chan time.Time, config.Concurrency)
+	go func() {
+		next := time.Now()
+		for {
+			next = next.Add(picker.nextInterval(config))
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case now := <-timer.C:
+				select {
+				case ticks <- now:
+				default:
+				}
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return ticks
+}
+
+func thinkTime(config Config) time.Duration {
+	d := config.ThinkTime
+	if config.ThinkJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*config.ThinkJitter)+1)) - config.ThinkJitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// preflight sends the first request of the test and returns ErrPreflight
+// if it failed in a way that would fail every request. It is not retried,
+// so that an unreachable target fails fast.
+func preflight(ctx context.Context, client *http.Client, config Config, picker *picker) error {
+	t := picker.pick(0)
+	if t.steps != nil {
+		rendered, err := t.render(make(map[string]string))
+		if err != nil {
+			return nil
+		}
+		t = rendered
+	}
+	result := attempt(ctx, client, config, t)
+	if result.Error == nil {
+		return nil
+	}
+	switch classifyError(result.Error) {
+	case "dns", "connection refused", "tls":
+		return fmt.Errorf("%w: %w", ErrPreflight, result.Error)
+	}
+	return nil
+}
+
+// staggerDelay is the random delay before the first request of a worker.
+func staggerDelay(config Config) time.Duration {
+	return time.Duration(rand.Int63n(int64(config.Stagger)))
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// waitForRampUp blocks while the number of in-flight requests is at the
+// concurrency allowed at this point of the ramp-up or spike.
+func waitForRampUp(ctx context.Context, semaphore chan struct{}, config Config, start time.Time) {
+	for {
+		allowed := activeConcurrency(config, time.Since(start))
+		if allowed >= config.Concurrency || len(semaphore) < allowed {
+			return
+		}
+
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// activeConcurrency is the number of requests allowed in flight elapsed
+// into the test. It follows config.Spike or the adaptive controller when
+// set, and otherwise grows linearly from 1 to config.Concurrency over
+// config.RampUp.
+func activeConcurrency(config Config, elapsed time.Duration) int {
+	if config.adaptive != nil {
+		return config.adaptive.limit()
+	}
+	if config.Spike != nil {
+		return config.Spike.concurrencyAt(elapsed)
+	}
+	if config.RampUp > 0 && elapsed < config.RampUp {
+		return 1 + int(float64(config.Concurrency-1)*elapsed.Seconds()/config.RampUp.Seconds())
+	}
+	return config.Concurrency
+}
+
+// NewRequest builds the request that would be sent to the first URL or
+// endpoint, with its template actions expanded, without sending it.
+func NewRequest(config Config) (*http.Request, error) {
+	if len(config.URLs) == 0 && len(config.Endpoints) == 0 && len(config.Steps) == 0 {
+		return nil, errors.New("loadtest: no URLs configured")
+	}
+	targets, err := newTargets(config)
+	if err != nil {
+		return nil, err
+	}
+	target := targets[0]
+	if len(config.Bodies) > 0 {
+		target.body = config.Bodies[0]
+	}
+	if target.steps != nil {
+		if target, err = target.render(make(map[string]string)); err != nil {
+			return nil, err
+		}
+	}
+	return newRequest(context.Background(), config, target)
+}
+
+func newRequest(ctx context.Context, config Config, target target) (*http.Request, error) {
+	// Every request, retry included, reads the shared body through its own
+	// reader, and http.NewRequest derives GetBody from it so redirects can
+	// resend the body too.
+	var reqBody io.Reader
+	if len(target.body) > 0 {
+		reqBody = bytes.NewReader(target.body)
+	}
+
+	expanded, err := target.url.expandWith(target.vars)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, target.method, expanded, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if config.BodyFile != "" {
+		// The transport closes the file once the body is sent, and GetBody
+		// reopens it for redirects.
+		req.GetBody = func() (io.ReadCloser, error) {
+			return os.Open(config.BodyFile)
+		}
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		req.ContentLength = -1
+	}
+	for name, values := range target.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	for name, values := range config.RandomHeaders {
+		req.Header.Set(name, values[rand.Intn(len(values))])
+	}
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+	if config.Host != "" {
+		req.Host = config.Host
+	}
+	// Asking for compression explicitly stops the transport from
+	// decompressing transparently, so readBody can count wire bytes.
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	return req, nil
+}
+
+// sendRequest performs a request, retrying failed attempts as configured,
+// and returns the result of the last attempt.
+func sendRequest(ctx context.Context, client *http.Client, config Config, target target) Result {
+	var queueTime time.Duration
+	inFlight := 0
+	if config.inflight != nil {
+		queued := time.Now()
+		select {
+		case config.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return Result{Method: target.method, URL: target.url.raw, Endpoint: target.name, Start: queued, Error: ctx.Err()}
+		default:
+			if config.DropAtMaxInFlight {
+				return Result{Method: target.method, URL: target.url.raw, Endpoint: target.name, Start: queued, Dropped: true}
+			}
+			select {
+			case config.inflight <- struct{}{}:
+			case <-ctx.Done():
+				return Result{Method: target.method, URL: target.url.raw, Endpoint: target.name, Start: queued, Error: ctx.Err()}
+			}
+		}
+		defer func() { <-config.inflight }()
+		queueTime = time.Since(queued)
+		inFlight = len(config.inflight)
+	}
+
+	result := attempt(ctx, client, config, target)
+	result.QueueTime = queueTime
+	result.InFlight = inFlight
+	rateLimited := result.RateLimited
+	for result.Retries < config.Retries && shouldRetry(config, result) {
+		delay := config.RetryBackoff << result.Retries
+		if result.retryAfter > 0 {
+			delay = result.retryAfter
+		}
+		sleep(ctx, delay)
+		if ctx.Err() != nil {
+			break
+		}
+		retries := result.Retries + 1
+		result = attempt(ctx, client, config, target)
+		result.Retries = retries
+		result.QueueTime = queueTime
+		result.InFlight = inFlight
+		rateLimited = rateLimited || result.RateLimited
+	}
+	result.RateLimited = rateLimited
+	return result
+}
+
+func shouldRetry(config Config, result Result) bool {
+	if result.Error != nil {
+		var bodyErr *bodyValidationError
+		return !errors.As(result.Error, &bodyErr)
+	}
+	return result.RateLimited || (config.RetryServerErrors && result.StatusCode >= 500)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// attempt performs a single request. The Result is keyed by the configured
+// URL rather than its expanded form so that templated URLs are aggregated
+// together.
+func attempt(ctx context.Context, client *http.Client, config Config, target target) Result {
+	url := target.url.raw
+
+	req, err := newRequest(ctx, config, target)
+	if err != nil {
+		return Result{Method: target.method, URL: url, Endpoint: target.name, Start: time.Now(), Error: err}
+	}
+
+	var trace tracer
+	reqCtx := req.Context()
+	if config.TTFBTimeout > 0 {
+		var cancel context.CancelCauseFunc
+		reqCtx, cancel = context.WithCancelCause(reqCtx)
+		defer cancel(nil)
+		timer := time.AfterFunc(config.TTFBTimeout, func() { cancel(errTTFBTimeout) })
+		defer timer.Stop()
+		trace.firstByte = func() { timer.Stop() }
+	}
+	req = req.WithContext(trace.withTrace(reqCtx))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil && context.Cause(reqCtx) == errTTFBTimeout {
+		err = fmt.Errorf("%w after %s", errTTFBTimeout, config.TTFBTimeout)
+	}
+
+	result := Result{
+		Method:   req.Method,
+		URL:      url,
+		Endpoint: target.name,
+		Start:    start,
+		Error:    err,
+	}
+
+	if err == nil {
+		result.StatusCode = resp.StatusCode
+		result.Proto = resp.Proto
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			var ok bool
+			result.retryAfter, ok = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			result.RateLimited = ok || resp.StatusCode == http.StatusTooManyRequests
+		}
+		if config.VerboseHeaders || len(config.TrackHeaders) > 0 {
+			result.Header = resp.Header
+		}
+		if !config.SkipBody {
+			keepBody := target.steps != nil && len(target.steps.extract) > 0
+			result.Bytes, result.WireBytes, result.body, result.Error = readBody(config, resp, keepBody)
+		}
+		resp.Body.Close()
+		if result.Error == nil && config.HTTPVersion == "2" && resp.ProtoMajor != 2 {
+			result.Error = fmt.Errorf("server responded with %s, HTTP/2 required", resp.Proto)
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Timing = trace.result()
+
+	return result
+}
@@ -0,0 +1,209 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newServer starts a test server that waits delay and then responds with
+// status.
+func newServer(t *testing.T, status int, delay time.Duration) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func run(t *testing.T, config Config) Report {
+	t.Helper()
+	report, err := Run(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return report
+}
+
+func TestRunSuccessfulRequests(t *testing.T) {
+	const delay = 10 * time.Millisecond
+	server := newServer(t, http.StatusOK, delay)
+
+	report := run(t, Config{URLs: []string{server.URL}, Concurrency: 4, Requests: 20})
+
+	if report.TotalRequests != 20 || report.SuccessfulRequests != 20 || report.FailedRequests != 0 {
+		t.Errorf("got %d requests, %d successful, %d failed; want 20, 20, 0",
+			report.TotalRequests, report.SuccessfulRequests, report.FailedRequests)
+	}
+	if len(report.StatusCodes) != 1 || report.StatusCodes[http.StatusOK] != 20 {
+		t.Errorf("StatusCodes = %v, want map[200:20]", report.StatusCodes)
+	}
+	if report.MinTime < delay {
+		t.Errorf("MinTime = %v, want at least the server delay %v", report.MinTime, delay)
+	}
+	if report.MinTime > report.AverageTime || report.AverageTime > report.MaxTime {
+		t.Errorf("want MinTime <= AverageTime <= MaxTime, got %v, %v, %v",
+			report.MinTime, report.AverageTime, report.MaxTime)
+	}
+}
+
+func TestRunServerErrors(t *testing.T) {
+	server := newServer(t, http.StatusInternalServerError, 0)
+
+	report := run(t, Config{URLs: []string{server.URL}, Concurrency: 4, Requests: 20})
+
+	// A 500 is a response, not a failed request, but it is not a success.
+	if report.SuccessfulRequests != 0 || report.FailedRequests != 0 {
+		t.Errorf("got %d successful and %d failed requests, want 0 and 0",
+			report.SuccessfulRequests, report.FailedRequests)
+	}
+	if len(report.StatusCodes) != 1 || report.StatusCodes[http.StatusInternalServerError] != 20 {
+		t.Errorf("StatusCodes = %v, want map[500:20]", report.StatusCodes)
+	}
+	if report.ErrorRate != 100 {
+		t.Errorf("ErrorRate = %v, want 100", report.ErrorRate)
+	}
+	if report.MinTime <= 0 || report.MinTime > report.AverageTime || report.AverageTime > report.MaxTime {
+		t.Errorf("want 0 < MinTime <= AverageTime <= MaxTime, got %v, %v, %v",
+			report.MinTime, report.AverageTime, report.MaxTime)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	server := newServer(t, http.StatusOK, time.Second)
+
+	report := run(t, Config{
+		URLs:        []string{server.URL},
+		Concurrency: 5,
+		Requests:    5,
+		Timeout:     50 * time.Millisecond,
+	})
+
+	if report.SuccessfulRequests != 0 || report.FailedRequests != 5 {
+		t.Errorf("got %d successful and %d failed requests, want 0 and 5",
+			report.SuccessfulRequests, report.FailedRequests)
+	}
+	if report.ErrorCategories["timeout"] != 5 {
+		t.Errorf("ErrorCategories = %v, want 5 timeouts", report.ErrorCategories)
+	}
+	if len(report.StatusCodes) != 0 {
+		t.Errorf("StatusCodes = %v, want none", report.StatusCodes)
+	}
+	// Failed requests are excluded from the response time statistics.
+	if report.MinTime != 0 || report.MaxTime != 0 || report.AverageTime != 0 {
+		t.Errorf("got MinTime %v, MaxTime %v, AverageTime %v; want all 0",
+			report.MinTime, report.MaxTime, report.AverageTime)
+	}
+}
+
+func TestRunSendsFullBodyConcurrently(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var received, mismatched int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		atomic.AddInt64(&received, 1)
+		if err != nil || !bytes.Equal(got, body) {
+			atomic.AddInt64(&mismatched, 1)
+		}
+	}))
+	defer server.Close()
+
+	report := run(t, Config{
+		URLs:        []string{server.URL},
+		Method:      http.MethodPost,
+		Body:        body,
+		Concurrency: 50,
+		Requests:    500,
+	})
+
+	if report.SuccessfulRequests != 500 || received != 500 {
+		t.Fatalf("got %d successful requests and %d received, want 500 of each", report.SuccessfulRequests, received)
+	}
+	if mismatched != 0 {
+		t.Errorf("%d of %d requests did not arrive with the full body", mismatched, received)
+	}
+}
+
+func TestPreflightFailsFast(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	started := time.Now()
+	_, err := Run(context.Background(), Config{
+		URLs:         []string{url},
+		Concurrency:  1,
+		Requests:     10,
+		Preflight:    true,
+		Retries:      3,
+		RetryBackoff: time.Second,
+	})
+
+	if !errors.Is(err, ErrPreflight) {
+		t.Fatalf("Run error = %v, want ErrPreflight", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("Run took %v, want the preflight request not to be retried", elapsed)
+	}
+}
+
+func TestWarmupWithOpenModelStages(t *testing.T) {
+	server := newServer(t, http.StatusOK, 0)
+
+	started := time.Now()
+	report := run(t, Config{
+		URLs:        []string{server.URL},
+		Concurrency: 2,
+		OpenModel:   true,
+		Stages:      []Stage{{Duration: 200 * time.Millisecond, Rate: 50}},
+		Warmup:      10,
+		MaxDuration: 5 * time.Second,
+	})
+
+	if report.WarmupRequests != 10 {
+		t.Errorf("WarmupRequests = %d, want 10", report.WarmupRequests)
+	}
+	// The warmup runs at the first stage's rate: 10 requests at 50 per
+	// second add at least 180ms to the 200ms stage.
+	if elapsed := time.Since(started); elapsed < 350*time.Millisecond {
+		t.Errorf("Run took %v, want the warmup paced at the first stage's rate", elapsed)
+	}
+	if report.DeadlineExceeded || report.SuccessfulRequests == 0 {
+		t.Errorf("got %d successful requests and DeadlineExceeded %t, want the stage to run",
+			report.SuccessfulRequests, report.DeadlineExceeded)
+	}
+}
+
+func TestPreflightBeforeMaxDuration(t *testing.T) {
+	server := newServer(t, http.StatusOK, 100*time.Millisecond)
+
+	report, err := Run(context.Background(), Config{
+		URLs:        []string{server.URL},
+		Concurrency: 1,
+		Requests:    1,
+		Preflight:   true,
+		MaxDuration: 150 * time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.SuccessfulRequests != 1 || report.DeadlineExceeded {
+		t.Errorf("got %d successful requests and DeadlineExceeded %t, want 1 and false",
+			report.SuccessfulRequests, report.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,162 @@
+package loadtest
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// sketchAccuracy is the relative error of the percentiles estimated by a
+// quantileSketch.
+const sketchAccuracy = 0.01
+
+// sketchGamma is the ratio between the bounds of consecutive sketch
+// buckets. Each bucket is reported by the value within sketchAccuracy of
+// all of it.
+var sketchGamma = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+
+// sketchBuckets covers durations far beyond any request timeout; the last
+// bucket absorbs anything longer.
+const sketchBuckets = 2048
+
+// quantileSketch estimates percentiles of durations in fixed memory by
+// counting them in logarithmically sized buckets. The minimum, maximum and
+// moments are exact.
+type quantileSketch struct {
+	counts           [sketchBuckets]int64
+	count            int64
+	sum, sumSquares  float64
+	minTime, maxTime time.Duration
+}
+
+func (s *quantileSketch) add(d time.Duration) {
+	if s.count == 0 || d < s.minTime {
+		s.minTime = d
+	}
+	if d > s.maxTime {
+		s.maxTime = d
+	}
+	s.count++
+	s.sum += float64(d)
+	s.sumSquares += float64(d) * float64(d)
+	s.counts[sketchIndex(d)]++
+}
+
+// sketchIndex returns the bucket counting d. Bucket i > 0 holds durations
+// in (gamma^(i-1), gamma^i] nanoseconds, and bucket 0 those up to 1ns.
+func sketchIndex(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)) / math.Log(sketchGamma)))
+	if i >= sketchBuckets {
+		return sketchBuckets - 1
+	}
+	return i
+}
+
+// percentile estimates the nearest-rank p-th percentile, within
+// sketchAccuracy of the exact one.
+func (s *quantileSketch) percentile(p float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(s.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank >= s.count {
+		return s.maxTime
+	}
+	var seen int64
+	for i, n := range s.counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		d := time.Duration(2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1))
+		if d < s.minTime {
+			return s.minTime
+		}
+		if d > s.maxTime {
+			return s.maxTime
+		}
+		return d
+	}
+	return s.maxTime
+}
+
+func (s *quantileSketch) stdDev() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	mean := s.sum / float64(s.count)
+	return time.Duration(math.Sqrt(math.Max(0, s.sumSquares/float64(s.count)-mean*mean)))
+}
+
+// latencies records response times for their percentiles. The zero value
+// keeps every sample; newLatencies(true) keeps a quantileSketch instead so
+// that memory does not grow with the number of requests.
+type latencies struct {
+	sketch  *quantileSketch
+	samples []time.Duration
+	sorted  bool
+}
+
+func newLatencies(streaming bool) latencies {
+	if streaming {
+		return latencies{sketch: &quantileSketch{}}
+	}
+	return latencies{}
+}
+
+func (l *latencies) add(d time.Duration) {
+	if l.sketch != nil {
+		l.sketch.add(d)
+		return
+	}
+	l.samples = append(l.samples, d)
+	l.sorted = false
+}
+
+func (l *latencies) sort() {
+	if !l.sorted {
+		sort.Slice(l.samples, func(i, j int) bool { return l.samples[i] < l.samples[j] })
+		l.sorted = true
+	}
+}
+
+func (l *latencies) percentile(p float64) time.Duration {
+	if l.sketch != nil {
+		return l.sketch.percentile(p)
+	}
+	l.sort()
+	return percentile(l.samples, p)
+}
+
+// median is the exact median, or the estimated P50 for a sketch.
+func (l *latencies) median() time.Duration {
+	if l.sketch != nil {
+		return l.sketch.percentile(50)
+	}
+	l.sort()
+	return median(l.samples)
+}
+
+func (l *latencies) minMax() (time.Duration, time.Duration) {
+	if l.sketch != nil {
+		return l.sketch.minTime, l.sketch.maxTime
+	}
+	if len(l.samples) == 0 {
+		return 0, 0
+	}
+	l.sort()
+	return l.samples[0], l.samples[len(l.samples)-1]
+}
+
+func (l *latencies) stdDev(mean time.Duration) time.Duration {
+	if l.sketch != nil {
+		return l.sketch.stdDev()
+	}
+	return stdDev(l.samples, mean)
+}
@@ -0,0 +1,142 @@
+package loadtest
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+// RunStats summarises one repetition of the test when Config.Repeat is set.
+type RunStats struct {
+	Requests           int           `json:"requests"`
+	SuccessfulRequests int           `json:"successful_requests"`
+	FailedRequests     int           `json:"failed_requests"`
+	MeasuredDuration   time.Duration `json:"measured_duration_ns"`
+	RequestsPerSecond  float64       `json:"requests_per_second"`
+	ErrorRate          float64       `json:"error_rate"`
+	AverageTime        time.Duration `json:"average_time_ns"`
+	P95                time.Duration `json:"p95_ns"`
+
+	first, last time.Time
+	totalTime   time.Duration
+	durations   latencies
+}
+
+// RunSpread is the mean and standard deviation across runs of the main
+// metrics of RunStats.
+type RunSpread struct {
+	RequestsPerSecondMean   float64       `json:"requests_per_second_mean"`
+	RequestsPerSecondStdDev float64       `json:"requests_per_second_std_dev"`
+	ErrorRateMean           float64       `json:"error_rate_mean"`
+	ErrorRateStdDev         float64       `json:"error_rate_std_dev"`
+	AverageTimeMean         time.Duration `json:"average_time_mean_ns"`
+	AverageTimeStdDev       time.Duration `json:"average_time_std_dev_ns"`
+	P95Mean                 time.Duration `json:"p95_mean_ns"`
+	P95StdDev               time.Duration `json:"p95_std_dev_ns"`
+}
+
+type dispatchFunc func(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result
+
+// repeated returns a dispatchFunc running dispatch config.Repeat times, with
+// config.Cooldown between runs, tagging each Result with its run index.
+// Idle connections are closed during the cooldown so that every run starts
+// afresh.
+func repeated(dispatch dispatchFunc) dispatchFunc {
+	return func(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result {
+		resultChan := make(chan Result, config.Concurrency)
+
+		go func() {
+			defer close(resultChan)
+			for i := 0; i < config.Repeat; i++ {
+				if i > 0 {
+					client.CloseIdleConnections()
+					sleep(ctx, config.Cooldown)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				for result := range dispatch(ctx, requestCtx, client, config, picker) {
+					result.Run = i
+					resultChan <- result
+				}
+			}
+		}()
+
+		return resultChan
+	}
+}
+
+func (r *RunStats) add(result Result, successCodes StatusMatcher, now time.Time) {
+	if r.first.IsZero() || result.Start.Before(r.first) {
+		r.first = result.Start
+	}
+	r.last = now
+	r.Requests++
+	if result.Error != nil {
+		r.FailedRequests++
+		return
+	}
+	if successCodes.Match(result.StatusCode) {
+		r.SuccessfulRequests++
+	}
+	r.totalTime += result.Duration
+	r.durations.add(result.Duration)
+}
+
+func (r *RunStats) finish() {
+	r.MeasuredDuration = r.last.Sub(r.first)
+	if r.MeasuredDuration > 0 {
+		r.RequestsPerSecond = float64(r.Requests) / r.MeasuredDuration.Seconds()
+	}
+	if r.Requests > 0 {
+		r.ErrorRate = float64(r.Requests-r.SuccessfulRequests) / float64(r.Requests) * 100
+	}
+	if completed := r.Requests - r.FailedRequests; completed > 0 {
+		r.AverageTime = r.totalTime / time.Duration(completed)
+		r.P95 = r.durations.percentile(95)
+	}
+	r.durations = latencies{}
+}
+
+// newRunSpread computes the spread of the runs that sent requests.
+func newRunSpread(runs []RunStats) *RunSpread {
+	var rps, errorRate, average, p95 []float64
+	for _, run := range runs {
+		if run.Requests == 0 {
+			continue
+		}
+		rps = append(rps, run.RequestsPerSecond)
+		errorRate = append(errorRate, run.ErrorRate)
+		average = append(average, float64(run.AverageTime))
+		p95 = append(p95, float64(run.P95))
+	}
+	if len(rps) == 0 {
+		return nil
+	}
+
+	spread := &RunSpread{}
+	spread.RequestsPerSecondMean, spread.RequestsPerSecondStdDev = meanStdDev(rps)
+	spread.ErrorRateMean, spread.ErrorRateStdDev = meanStdDev(errorRate)
+	mean, stdDev := meanStdDev(average)
+	spread.AverageTimeMean, spread.AverageTimeStdDev = time.Duration(mean), time.Duration(stdDev)
+	mean, stdDev = meanStdDev(p95)
+	spread.P95Mean, spread.P95StdDev = time.Duration(mean), time.Duration(stdDev)
+	return spread
+}
+
+// meanStdDev returns the mean and sample standard deviation of values.
+func meanStdDev(values []float64) (mean, stdDev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sum / float64(len(values)-1))
+}
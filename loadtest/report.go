@@ -0,0 +1,721 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report aggregates the results of a load test run. Durations are encoded
+// in JSON as nanoseconds. Response time statistics cover every completed
+// request regardless of status code; requests that errored are excluded.
+type Report struct {
+	// Label and Tags are copied from the Config. Version is the version of
+	// the tool that ran the test, left for callers to set.
+	Label     string            `json:"label,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	StartTime time.Time         `json:"start_time"`
+
+	TotalRequests int           `json:"total_requests"`
+	TotalDuration time.Duration `json:"total_duration_ns"`
+	// FirstRequest is when the first request was sent and LastResponse when
+	// the last result was received. Rates are computed over this window,
+	// which excludes startup and teardown.
+	FirstRequest       time.Time        `json:"first_request"`
+	LastResponse       time.Time        `json:"last_response"`
+	MeasuredDuration   time.Duration    `json:"measured_duration_ns"`
+	StatusCodes        map[int]int      `json:"status_codes"`
+	StatusLatencies    map[int]*Latency `json:"status_latencies"`
+	SuccessfulRequests int              `json:"successful_requests"`
+	FailedRequests     int              `json:"failed_requests"`
+	// SuccessRate is the percentage of requests with a success status code
+	// and ErrorRate the rest, counting both FailedRequests and other status
+	// codes. ErrorRate is therefore not FailedRequests / TotalRequests, which
+	// would report a server answering every request with a 500 as healthy.
+	SuccessRate        float64        `json:"success_rate"`
+	ErrorRate          float64        `json:"error_rate"`
+	ErrorCategories    map[string]int `json:"error_categories"`
+	ValidationFailures int            `json:"validation_failures"`
+	// SchemaFailures are the ValidationFailures of bodies that are valid JSON
+	// but do not match Config.JSONSchema.
+	SchemaFailures      int            `json:"schema_failures"`
+	SLO                 time.Duration  `json:"slo_ns"`
+	SLOViolations       int            `json:"slo_violations"`
+	SLOCompliance       float64        `json:"slo_compliance"`
+	SLOViolationRate    float64        `json:"slo_violation_rate"`
+	Retries             int            `json:"retries"`
+	RetriedRequests     int            `json:"retried_requests"`
+	RateLimitedRequests int            `json:"rate_limited_requests"`
+	Protocols           map[string]int `json:"protocols"`
+	// HeaderValues counts, for each of Config.TrackHeaders, the responses
+	// by value of that header. Responses without it count as "(none)", and
+	// values beyond the first maxHeaderValues as "(other)".
+	HeaderValues      map[string]map[string]int `json:"header_values,omitempty"`
+	RequestsPerSecond float64                   `json:"requests_per_second"`
+	// RequestedRate is Config.Rate, to compare RequestsPerSecond with, when
+	// the test ran at a fixed rate rather than in stages.
+	RequestedRate      float64       `json:"requested_rate,omitempty"`
+	TotalBytes         int64         `json:"total_bytes"`
+	TotalWireBytes     int64         `json:"total_wire_bytes"`
+	BytesPerSecond     float64       `json:"bytes_per_second"`
+	WireBytesPerSecond float64       `json:"wire_bytes_per_second"`
+	AverageTime        time.Duration `json:"average_time_ns"`
+	Median             time.Duration `json:"median_ns"`
+	StdDev             time.Duration `json:"std_dev_ns"`
+	MinTime            time.Duration `json:"min_time_ns"`
+	MaxTime            time.Duration `json:"max_time_ns"`
+	// Connection timing averages cover the requests that went through
+	// each phase, so e.g. AverageConnect ignores reused connections.
+	AverageDNS     time.Duration `json:"average_dns_ns"`
+	AverageConnect time.Duration `json:"average_connect_ns"`
+	AverageTLS     time.Duration `json:"average_tls_ns"`
+	AverageTTFB    time.Duration `json:"average_ttfb_ns"`
+	// ReusedConnections and NewConnections count the requests, failed ones
+	// included, that got a kept-alive connection or opened a new one, and
+	// ConnectionReuseRate is the percentage of the former.
+	ReusedConnections   int           `json:"reused_connections"`
+	NewConnections      int           `json:"new_connections"`
+	ConnectionReuseRate float64       `json:"connection_reuse_rate"`
+	P50                 time.Duration `json:"p50_ns"`
+	P90                 time.Duration `json:"p90_ns"`
+	P95                 time.Duration `json:"p95_ns"`
+	P99                 time.Duration `json:"p99_ns"`
+	// AchievedConcurrency is the average number of requests in flight over
+	// the measured window, against the RequestedConcurrency. Saturated is
+	// set when it fell far short although nothing in the Config held
+	// requests back, suggesting the load generator is the bottleneck.
+	RequestedConcurrency int     `json:"requested_concurrency"`
+	AchievedConcurrency  float64 `json:"achieved_concurrency"`
+	Saturated            bool    `json:"saturated"`
+	MaxInFlight          int     `json:"max_in_flight"`
+	// DroppedRequests were not sent because Config.MaxInFlight was reached
+	// with Config.DropAtMaxInFlight set; they are not in TotalRequests.
+	DroppedRequests  int               `json:"dropped_requests"`
+	AverageQueueTime time.Duration     `json:"average_queue_time_ns"`
+	MaxQueueTime     time.Duration     `json:"max_queue_time_ns"`
+	WarmupRequests   int               `json:"warmup_requests"`
+	Interrupted      bool              `json:"interrupted"`
+	DeadlineExceeded bool              `json:"deadline_exceeded"`
+	StoppedOnError   bool              `json:"stopped_on_error"`
+	Histogram        []HistogramBucket `json:"histogram"`
+	// TimeSeries breaks the results down by Config.TimeSeriesInterval.
+	TimeSeries []TimeSeriesPoint `json:"time_series,omitempty"`
+	Stages     []StageStats      `json:"stages,omitempty"`
+	// Runs break the report down by repetition when Config.Repeat is set,
+	// and RunSpread gives the variation of their main metrics.
+	Runs      []RunStats `json:"runs,omitempty"`
+	RunSpread *RunSpread `json:"run_spread,omitempty"`
+	KneePoint *KneePoint `json:"knee_point,omitempty"`
+	// Adaptive reports the intervals of an adaptive test and the best
+	// concurrency found.
+	Adaptive *AdaptiveStats `json:"adaptive,omitempty"`
+	// MaxSustainedRPS is, for a spike, the highest request rate over the
+	// last 100 results while their error rate stayed within
+	// Config.KneeErrorRate (or without errors when it is not set), up to
+	// the knee point.
+	MaxSustainedRPS float64 `json:"max_sustained_rps,omitempty"`
+	// SlowestRequests are the Config.TopSlow slowest requests, slowest
+	// first, including failed ones.
+	SlowestRequests []SlowRequest        `json:"slowest_requests,omitempty"`
+	URLStats        map[string]*URLStats `json:"url_stats,omitempty"`
+}
+
+// DefaultBuckets are the latency histogram bounds used when
+// Config.Buckets is empty.
+var DefaultBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// HistogramBucket counts responses with Min <= duration < Max. The last
+// bucket has Max set to 0 and is unbounded.
+type HistogramBucket struct {
+	Min   time.Duration `json:"min_ns"`
+	Max   time.Duration `json:"max_ns"`
+	Count int           `json:"count"`
+}
+
+// Latency summarises the response times of the requests that received one
+// status code.
+type Latency struct {
+	MinTime     time.Duration `json:"min_time_ns"`
+	AverageTime time.Duration `json:"average_time_ns"`
+	MaxTime     time.Duration `json:"max_time_ns"`
+
+	totalTime time.Duration
+	count     int
+}
+
+func (l *Latency) add(d time.Duration) {
+	if l.count == 0 || d < l.MinTime {
+		l.MinTime = d
+	}
+	if d > l.MaxTime {
+		l.MaxTime = d
+	}
+	l.totalTime += d
+	l.count++
+	l.AverageTime = l.totalTime / time.Duration(l.count)
+}
+
+// URLStats holds the per-URL breakdown of a multi-URL run, or the
+// per-endpoint breakdown of a scenario, mix or session, keyed by Endpoint
+// name.
+type URLStats struct {
+	Requests           int           `json:"requests"`
+	SuccessfulRequests int           `json:"successful_requests"`
+	FailedRequests     int           `json:"failed_requests"`
+	AverageTime        time.Duration `json:"average_time_ns"`
+
+	totalTime time.Duration
+}
+
+// collect aggregates the results from resultChan into a Report, calling stop
+// when config.StopOnError is set and a request fails.
+func collect(config Config, resultChan <-chan Result, startTime time.Time, stop func()) Report {
+	report := Report{
+		Label:           config.Label,
+		Tags:            config.Tags,
+		StartTime:       startTime,
+		StatusCodes:     make(map[int]int),
+		StatusLatencies: make(map[int]*Latency),
+		ErrorCategories: make(map[string]int),
+		Protocols:       make(map[string]int),
+	}
+	if config.Repeat > 1 {
+		report.Runs = make([]RunStats, config.Repeat)
+		for i := range report.Runs {
+			report.Runs[i].durations = newLatencies(config.StreamingQuantiles)
+		}
+	}
+	if len(config.Stages) > 0 {
+		report.Stages = newStageStats(config)
+	}
+	// A scenario, mix or session is broken down even with a single entry,
+	// since the entry names are part of what was asked for.
+	if len(config.URLs) > 1 || len(config.Endpoints) > 0 || len(config.Steps) > 0 {
+		report.URLStats = make(map[string]*URLStats)
+	}
+	if len(config.TrackHeaders) > 0 {
+		report.HeaderValues = make(map[string]map[string]int)
+		for _, name := range config.TrackHeaders {
+			report.HeaderValues[http.CanonicalHeaderKey(name)] = make(map[string]int)
+		}
+	}
+
+	var totalTime, totalQueueTime, busyTime time.Duration
+	var dns, connect, tlsTime, ttfb average
+	durations := newLatencies(config.StreamingQuantiles)
+	buckets := newHistogram(config.Buckets)
+	completed := 0
+	var lastProgress time.Time
+
+	if config.CSV != nil {
+		header := []string{"timestamp", "url", "status_code", "duration_ms", "error"}
+		if config.Label != "" {
+			header = append(header, "label")
+		}
+		for _, name := range tagNames(config.Tags) {
+			header = append(header, "tag_"+name)
+		}
+		config.CSV.Write(header)
+	}
+
+	var tick <-chan time.Time
+	if config.Interval > 0 && config.Interim != nil {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	window := interimWindow{start: startTime}
+	var knee kneeDetector
+	slow := slowest{n: config.TopSlow}
+	var series *timeSeries
+	if config.TimeSeriesInterval > 0 {
+		series = &timeSeries{interval: config.TimeSeriesInterval, start: startTime, streaming: config.StreamingQuantiles}
+	}
+
+loop:
+	for {
+		var result Result
+		select {
+		case r, ok := <-resultChan:
+			if !ok {
+				break loop
+			}
+			result = r
+		case now := <-tick:
+			window.print(config.Interim, now.Sub(startTime))
+			window = interimWindow{start: now}
+			continue
+		}
+
+		// Requests cut short by stopping on an error are not counted.
+		if report.StoppedOnError && errors.Is(result.Error, context.Canceled) {
+			continue
+		}
+		if result.Dropped {
+			report.DroppedRequests++
+			continue
+		}
+
+		now := time.Now()
+		if report.FirstRequest.IsZero() || result.Start.Before(report.FirstRequest) {
+			report.FirstRequest = result.Start
+		}
+		report.LastResponse = now
+		slow.add(result)
+		if series != nil {
+			series.add(result, now, config.SuccessCodes)
+		}
+
+		window.add(result, config.SuccessCodes)
+		failed := result.Error != nil || !config.SuccessCodes.Match(result.StatusCode)
+		if (config.KneeErrorRate > 0 || config.Spike != nil) && report.KneePoint == nil {
+			errorRate, rps, full := knee.add(failed, now)
+			if full && config.Spike != nil && errorRate <= config.KneeErrorRate && rps > report.MaxSustainedRPS {
+				report.MaxSustainedRPS = rps
+			}
+			if full && config.KneeErrorRate > 0 && errorRate > config.KneeErrorRate {
+				elapsed := now.Sub(startTime)
+				rate, concurrency, stage := loadAt(config, result.Stage, elapsed)
+				report.KneePoint = &KneePoint{
+					Elapsed:           elapsed,
+					Stage:             stage,
+					Rate:              rate,
+					Concurrency:       concurrency,
+					RequestsPerSecond: rps,
+					ErrorRate:         errorRate,
+				}
+			}
+		}
+		if config.StopOnError && !report.StoppedOnError && failed {
+			report.StoppedOnError = true
+			stop()
+		}
+		report.TotalRequests++
+		busyTime += result.Duration
+		report.Retries += result.Retries
+		if result.Retries > 0 {
+			report.RetriedRequests++
+		}
+		if result.RateLimited {
+			report.RateLimitedRequests++
+		}
+		report.TotalBytes += result.Bytes
+		totalQueueTime += result.QueueTime
+		if result.QueueTime > report.MaxQueueTime {
+			report.MaxQueueTime = result.QueueTime
+		}
+		if result.InFlight > report.MaxInFlight {
+			report.MaxInFlight = result.InFlight
+		}
+		report.TotalWireBytes += result.WireBytes
+		if result.Proto != "" {
+			report.Protocols[result.Proto]++
+		}
+		if result.Header != nil {
+			for name, counts := range report.HeaderValues {
+				countHeader(counts, result.Header.Values(name))
+			}
+		}
+		if result.Timing.Reused {
+			report.ReusedConnections++
+		} else if result.Timing.GotConn {
+			report.NewConnections++
+		}
+
+		if config.CSV != nil {
+			writeCSVRow(config, result)
+		}
+
+		if config.Verbose != nil && report.TotalRequests <= maxVerboseRequests {
+			logResult(config, result)
+			if report.TotalRequests == maxVerboseRequests {
+				fmt.Fprintf(config.Verbose, "Verbose logging suppressed after %d requests\n", maxVerboseRequests)
+			}
+		}
+
+		if config.Progress != nil && time.Since(lastProgress) >= 100*time.Millisecond {
+			lastProgress = time.Now()
+			printProgress(config, report.TotalRequests, time.Since(startTime))
+		}
+
+		if report.Runs != nil {
+			report.Runs[result.Run].add(result, config.SuccessCodes, now)
+		}
+
+		var stage *StageStats
+		if report.Stages != nil {
+			stage = &report.Stages[result.Stage]
+			stage.Requests++
+		}
+
+		var urlStats *URLStats
+		if report.URLStats != nil {
+			urlStats = report.URLStats[result.Endpoint]
+			if urlStats == nil {
+				urlStats = &URLStats{}
+				report.URLStats[result.Endpoint] = urlStats
+			}
+			urlStats.Requests++
+		}
+
+		if result.Error != nil {
+			report.FailedRequests++
+			report.ErrorCategories[classifyError(result.Error)]++
+			var bodyErr *bodyValidationError
+			if errors.As(result.Error, &bodyErr) {
+				report.ValidationFailures++
+				if bodyErr.schema {
+					report.SchemaFailures++
+				}
+			}
+			if urlStats != nil {
+				urlStats.FailedRequests++
+			}
+			if stage != nil {
+				stage.FailedRequests++
+			}
+			continue
+		}
+
+		if urlStats != nil {
+			urlStats.totalTime += result.Duration
+			if config.SuccessCodes.Match(result.StatusCode) {
+				urlStats.SuccessfulRequests++
+			}
+		}
+
+		dns.add(result.Timing.DNS)
+		connect.add(result.Timing.Connect)
+		tlsTime.add(result.Timing.TLS)
+		ttfb.add(result.Timing.TTFB)
+
+		if config.SLO > 0 && result.Duration > config.SLO {
+			report.SLOViolations++
+		}
+
+		if stage != nil {
+			stage.totalTime += result.Duration
+			stage.durations.add(result.Duration)
+			if config.SuccessCodes.Match(result.StatusCode) {
+				stage.SuccessfulRequests++
+			}
+		}
+
+		report.StatusCodes[result.StatusCode]++
+		latency := report.StatusLatencies[result.StatusCode]
+		if latency == nil {
+			latency = &Latency{}
+			report.StatusLatencies[result.StatusCode] = latency
+		}
+		latency.add(result.Duration)
+		totalTime += result.Duration
+		durations.add(result.Duration)
+		buckets.add(result.Duration, config.Buckets)
+		completed++
+
+		if config.SuccessCodes.Match(result.StatusCode) {
+			report.SuccessfulRequests++
+		}
+	}
+
+	report.TotalDuration = time.Since(startTime)
+	if series != nil {
+		report.TimeSeries = series.finish(report.TotalDuration)
+	}
+	if config.CSV != nil {
+		config.CSV.Flush()
+	}
+	if config.Progress != nil {
+		printProgress(config, report.TotalRequests, report.TotalDuration)
+		fmt.Fprintln(config.Progress)
+	}
+	report.MeasuredDuration = report.LastResponse.Sub(report.FirstRequest)
+	if report.Runs != nil {
+		// Cooldowns between runs are not measured.
+		report.MeasuredDuration = 0
+		for i := range report.Runs {
+			report.Runs[i].finish()
+			report.MeasuredDuration += report.Runs[i].MeasuredDuration
+		}
+		report.RunSpread = newRunSpread(report.Runs)
+	}
+	measured := report.MeasuredDuration
+	if measured <= 0 {
+		measured = report.TotalDuration
+	}
+	report.RequestsPerSecond = float64(report.TotalRequests) / measured.Seconds()
+	report.BytesPerSecond = float64(report.TotalBytes) / measured.Seconds()
+	report.WireBytesPerSecond = float64(report.TotalWireBytes) / measured.Seconds()
+	if completed > 0 {
+		report.AverageTime = totalTime / time.Duration(completed)
+	}
+	if len(config.Stages) == 0 {
+		report.RequestedRate = config.Rate
+	}
+	report.RequestedConcurrency = requestedConcurrency(config)
+	report.AchievedConcurrency = busyTime.Seconds() / measured.Seconds()
+	report.Saturated = saturated(config, report)
+	report.SlowestRequests = slow.requests()
+	report.SLO = config.SLO
+	if config.SLO > 0 && completed > 0 {
+		report.SLOCompliance = float64(completed-report.SLOViolations) / float64(completed) * 100
+		report.SLOViolationRate = 100 - report.SLOCompliance
+	}
+	if report.TotalRequests > 0 {
+		report.SuccessRate = float64(report.SuccessfulRequests) / float64(report.TotalRequests) * 100
+		report.ErrorRate = 100 - report.SuccessRate
+	}
+	report.AverageDNS = dns.value()
+	report.AverageConnect = connect.value()
+	report.AverageTLS = tlsTime.value()
+	report.AverageTTFB = ttfb.value()
+	if conns := report.ReusedConnections + report.NewConnections; conns > 0 {
+		report.ConnectionReuseRate = float64(report.ReusedConnections) / float64(conns) * 100
+	}
+	if report.TotalRequests > 0 {
+		report.AverageQueueTime = totalQueueTime / time.Duration(report.TotalRequests)
+	}
+
+	report.StdDev = durations.stdDev(report.AverageTime)
+
+	for i := range report.Stages {
+		stage := &report.Stages[i]
+		stage.RequestsPerSecond = float64(stage.Requests) / stage.Duration.Seconds()
+		if completed := stage.Requests - stage.FailedRequests; completed > 0 {
+			stage.AverageTime = stage.totalTime / time.Duration(completed)
+		}
+		stage.P95 = stage.durations.percentile(95)
+		stage.durations = latencies{}
+	}
+
+	for _, urlStats := range report.URLStats {
+		if completed := urlStats.Requests - urlStats.FailedRequests; completed > 0 {
+			urlStats.AverageTime = urlStats.totalTime / time.Duration(completed)
+		}
+	}
+
+	report.Histogram = buckets
+	report.MinTime, report.MaxTime = durations.minMax()
+	report.Median = durations.median()
+	report.P50 = durations.percentile(50)
+	report.P90 = durations.percentile(90)
+	report.P95 = durations.percentile(95)
+	report.P99 = durations.percentile(99)
+
+	return report
+}
+
+// maxHeaderValues caps the distinct values counted for a tracked header, so
+// that a header such as Date does not grow the report with every response.
+const maxHeaderValues = 100
+
+func countHeader(counts map[string]int, values []string) {
+	value := strings.Join(values, ", ")
+	if len(values) == 0 {
+		value = "(none)"
+	}
+	if _, ok := counts[value]; !ok && len(counts) >= maxHeaderValues {
+		value = "(other)"
+	}
+	counts[value]++
+}
+
+// average accumulates the mean of the non-zero durations added to it.
+type average struct {
+	total time.Duration
+	count int
+}
+
+func (a *average) add(d time.Duration) {
+	if d > 0 {
+		a.total += d
+		a.count++
+	}
+}
+
+func (a average) value() time.Duration {
+	if a.count == 0 {
+		return 0
+	}
+	return a.total / time.Duration(a.count)
+}
+
+// interimWindow accumulates the results received since the last interim
+// report.
+type interimWindow struct {
+	start     time.Time
+	requests  int
+	errors    int
+	durations []time.Duration
+}
+
+func (w *interimWindow) add(result Result, successCodes StatusMatcher) {
+	w.requests++
+	if result.Error != nil {
+		w.errors++
+		return
+	}
+	if !successCodes.Match(result.StatusCode) {
+		w.errors++
+	}
+	w.durations = append(w.durations, result.Duration)
+}
+
+func (w *interimWindow) print(out io.Writer, elapsed time.Duration) {
+	rps := float64(w.requests) / time.Since(w.start).Seconds()
+	errorRate := 0.0
+	if w.requests > 0 {
+		errorRate = float64(w.errors) / float64(w.requests) * 100
+	}
+	sort.Slice(w.durations, func(i, j int) bool { return w.durations[i] < w.durations[j] })
+	fmt.Fprintf(out, "[%v] rps=%.2f error_rate=%.2f%% p95=%v\n",
+		elapsed.Round(time.Millisecond), rps, errorRate, percentile(w.durations, 95))
+}
+
+// maxVerboseRequests caps verbose logging so large runs don't flood the
+// terminal.
+const maxVerboseRequests = 1000
+
+func logResult(config Config, result Result) {
+	if result.Error != nil {
+		fmt.Fprintf(config.Verbose, "%s %s error=%q duration=%v\n",
+			result.Method, result.URL, result.Error, result.Duration)
+		return
+	}
+	fmt.Fprintf(config.Verbose, "%s %s status=%d duration=%v\n",
+		result.Method, result.URL, result.StatusCode, result.Duration)
+	if config.VerboseHeaders {
+		names := make([]string, 0, len(result.Header))
+		for name := range result.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range result.Header[name] {
+				fmt.Fprintf(config.Verbose, "  %s: %s\n", name, value)
+			}
+		}
+	}
+}
+
+func printProgress(config Config, completed int, elapsed time.Duration) {
+	rps := float64(completed) / elapsed.Seconds()
+	if config.Duration > 0 {
+		fmt.Fprintf(config.Progress, "\rCompleted: %d (%v/%v, %.2f req/s)   ",
+			completed, elapsed.Truncate(time.Second), config.Duration, rps)
+		return
+	}
+	total := config.Requests
+	if len(config.Steps) > 0 {
+		// Sessions that fail part way send fewer requests than this.
+		total *= len(config.Steps)
+	}
+	fmt.Fprintf(config.Progress, "\rCompleted: %d/%d (%.2f req/s)   ", completed, total, rps)
+}
+
+// writeCSVRow writes result to config.CSV, followed by config.Label and
+// config.Tags, if set, in the order of the header.
+func writeCSVRow(config Config, result Result) {
+	errorText := ""
+	if result.Error != nil {
+		errorText = result.Error.Error()
+	}
+	row := []string{
+		result.Start.Format(time.RFC3339Nano),
+		result.URL,
+		strconv.Itoa(result.StatusCode),
+		strconv.FormatFloat(float64(result.Duration)/float64(time.Millisecond), 'f', 3, 64),
+		errorText,
+	}
+	if config.Label != "" {
+		row = append(row, config.Label)
+	}
+	for _, name := range tagNames(config.Tags) {
+		row = append(row, config.Tags[name])
+	}
+	config.CSV.Write(row)
+}
+
+// tagNames returns the names of tags in sorted order.
+func tagNames(tags map[string]string) []string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// histogram counts durations in the buckets delimited by the sorted bounds.
+type histogram []HistogramBucket
+
+func newHistogram(bounds []time.Duration) histogram {
+	buckets := make(histogram, len(bounds)+1)
+	var lower time.Duration
+	for i, bound := range bounds {
+		buckets[i] = HistogramBucket{Min: lower, Max: bound}
+		lower = bound
+	}
+	buckets[len(bounds)] = HistogramBucket{Min: lower}
+	return buckets
+}
+
+func (h histogram) add(d time.Duration, bounds []time.Duration) {
+	h[sort.Search(len(bounds), func(i int) bool { return d < bounds[i] })].Count++
+}
+
+func stdDev(durations []time.Duration, mean time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, d := range durations {
+		diff := float64(d - mean)
+		sum += diff * diff
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(durations))))
+}
+
+// median returns the middle value of sorted, or the mean of the two middle
+// values when their number is even. Unlike P50 it may not be a sample.
+func median(sorted []time.Duration) time.Duration {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank
+// method: the smallest value such that at least p percent of the samples
+// are less than or equal to it.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
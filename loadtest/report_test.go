@@ -0,0 +1,116 @@
+package loadtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAverageTimeCoversEveryResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			time.Sleep(20 * time.Millisecond)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	report := run(t, Config{
+		URLs:        []string{server.URL + "/ok", server.URL + "/missing"},
+		Concurrency: 2,
+		Requests:    20,
+	})
+
+	if report.SuccessfulRequests != 10 || report.StatusCodes[http.StatusNotFound] != 10 {
+		t.Fatalf("got %d successful requests and status codes %v, want 10 of each",
+			report.SuccessfulRequests, report.StatusCodes)
+	}
+	// The average, minimum and maximum all cover the 200s and the 404s.
+	ok, missing := report.StatusLatencies[http.StatusOK], report.StatusLatencies[http.StatusNotFound]
+	want := (ok.AverageTime*10 + missing.AverageTime*10) / 20
+	if diff := report.AverageTime - want; diff < -time.Microsecond || diff > time.Microsecond {
+		t.Errorf("AverageTime = %v, want the average of both status codes, %v", report.AverageTime, want)
+	}
+	if report.MinTime != ok.MinTime || report.MaxTime != missing.MaxTime {
+		t.Errorf("got MinTime %v and MaxTime %v, want %v and %v",
+			report.MinTime, report.MaxTime, ok.MinTime, missing.MaxTime)
+	}
+}
+
+func TestMinTimeWhenEveryRequestFails(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	report := run(t, Config{URLs: []string{url}, Concurrency: 2, Requests: 10})
+
+	if report.FailedRequests != 10 {
+		t.Fatalf("FailedRequests = %d, want 10", report.FailedRequests)
+	}
+	if report.MinTime != 0 || report.MaxTime != 0 || report.AverageTime != 0 || report.Median != 0 {
+		t.Errorf("got MinTime %v, MaxTime %v, AverageTime %v, Median %v; want all 0",
+			report.MinTime, report.MaxTime, report.AverageTime, report.Median)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      time.Duration
+	}{
+		{"empty", nil, 0},
+		{"one", []time.Duration{7 * ms}, 7 * ms},
+		{"odd", []time.Duration{9 * ms, 1 * ms, 5 * ms, 3 * ms, 100 * ms}, 5 * ms},
+		{"even", []time.Duration{4 * ms, 1 * ms, 10 * ms, 2 * ms}, 3 * ms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l latencies
+			for _, d := range tt.durations {
+				l.add(d)
+			}
+			if got := l.median(); got != tt.want {
+				t.Errorf("median of %v = %v, want %v", tt.durations, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestsPerSecond(t *testing.T) {
+	const (
+		latency     = 20 * time.Millisecond
+		concurrency = 5
+	)
+	server := newServer(t, http.StatusOK, latency)
+
+	report := run(t, Config{URLs: []string{server.URL}, Concurrency: concurrency, Requests: 100})
+
+	// Each worker completes one request per latency.
+	want := concurrency / latency.Seconds()
+	if report.RequestsPerSecond < want*0.8 || report.RequestsPerSecond > want*1.05 {
+		t.Errorf("RequestsPerSecond = %.1f, want within 80-105%% of %.1f", report.RequestsPerSecond, want)
+	}
+	if report.MeasuredDuration <= 0 || report.MeasuredDuration > report.TotalDuration {
+		t.Errorf("MeasuredDuration = %v, want positive and at most TotalDuration %v",
+			report.MeasuredDuration, report.TotalDuration)
+	}
+}
+
+func TestURLStatsForSingleEndpoint(t *testing.T) {
+	server := newServer(t, http.StatusOK, 0)
+
+	report := run(t, Config{
+		Endpoints:   []Endpoint{{Name: "read", Method: http.MethodGet, URL: server.URL}},
+		Concurrency: 2,
+		Requests:    10,
+	})
+
+	if stats := report.URLStats["read"]; len(report.URLStats) != 1 || stats == nil || stats.Requests != 10 {
+		t.Errorf("URLStats = %v, want 10 requests for read", report.URLStats)
+	}
+}
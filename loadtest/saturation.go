@@ -0,0 +1,34 @@
+package loadtest
+
+// saturationRatio is the fraction of the requested concurrency below which
+// the achieved concurrency is reported as saturation of the load
+// generator.
+const saturationRatio = 0.5
+
+// requestedConcurrency is the number of requests config asks to keep in
+// flight.
+func requestedConcurrency(config Config) int {
+	n := maxConcurrency(config)
+	if config.MaxInFlight > 0 && config.MaxInFlight < n {
+		n = config.MaxInFlight
+	}
+	return n
+}
+
+// saturated reports whether the achieved concurrency fell far short of the
+// requested level although nothing in config holds requests back, which
+// points at the load generator rather than the server: workers blocked on
+// file descriptors, CPU or connection setup. Rate limits, ramps, stages,
+// adaptive load and think time lower the concurrency by design and are not
+// checked, nor are runs too short for the requests at the end to be in the
+// minority.
+func saturated(config Config, report Report) bool {
+	if config.Rate > 0 || config.OpenModel || config.RampUp > 0 || config.Spike != nil || config.Adaptive != nil || len(config.Stages) > 0 ||
+		config.ThinkTime > 0 || config.ThinkJitter > 0 {
+		return false
+	}
+	if report.TotalRequests < 4*report.RequestedConcurrency {
+		return false
+	}
+	return report.AchievedConcurrency < saturationRatio*float64(report.RequestedConcurrency)
+}
@@ -0,0 +1,230 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Step is one request of the session each virtual user runs in order when
+// Config.Steps is set. Its URL, Body and header values are templates that,
+// besides the functions of URL templates, can refer to the values extracted
+// by earlier steps as {{.name}}.
+type Step struct {
+	// Name identifies the step in the report; it defaults to "METHOD URL".
+	Name    string
+	Method  string
+	URL     string
+	Body    string
+	Headers http.Header
+	Extract []Extract
+}
+
+// Extract captures a value from a step's response body for later steps.
+// Regexp captures its first group, or the whole match if it has none.
+// JSONPath is a dot-separated path of object keys and array indexes, such
+// as "data.items.0.id". Exactly one of them must be set.
+type Extract struct {
+	Name     string
+	Regexp   *regexp.Regexp
+	JSONPath string
+}
+
+// stepTemplates are the parsed body and header templates of a step target.
+type stepTemplates struct {
+	body    urlTemplate
+	headers map[string][]urlTemplate
+	extract []Extract
+}
+
+func newStepTargets(config Config) ([]target, error) {
+	targets := make([]target, len(config.Steps))
+	for i, step := range config.Steps {
+		urls, err := parseURLTemplates([]string{step.URL, step.Body})
+		if err != nil {
+			return nil, err
+		}
+		t := target{
+			name:    step.Name,
+			method:  step.Method,
+			url:     urls[0],
+			body:    []byte(step.Body),
+			headers: config.Headers.Clone(),
+			steps: &stepTemplates{
+				body:    urls[1],
+				headers: make(map[string][]urlTemplate),
+				extract: step.Extract,
+			},
+		}
+		if t.method == "" {
+			t.method = http.MethodGet
+		}
+		if t.name == "" {
+			t.name = t.method + " " + step.URL
+		}
+		if t.headers == nil {
+			t.headers = make(http.Header)
+		}
+		for name, values := range step.Headers {
+			templates, err := parseURLTemplates(values)
+			if err != nil {
+				return nil, err
+			}
+			name = http.CanonicalHeaderKey(name)
+			t.headers[name] = values
+			t.steps.headers[name] = templates
+		}
+		for _, extract := range step.Extract {
+			if extract.Name == "" || (extract.Regexp == nil) == (extract.JSONPath == "") {
+				return nil, fmt.Errorf("loadtest: step %q needs a name and exactly one of a regexp or JSON path for each extraction", t.name)
+			}
+		}
+		targets[i] = t
+	}
+	return targets, nil
+}
+
+// render returns t with its body and headers expanded for the given
+// session variables.
+func (t target) render(vars map[string]string) (target, error) {
+	body, err := t.steps.body.expandWith(vars)
+	if err != nil {
+		return t, err
+	}
+	t.body = []byte(body)
+	t.headers = t.headers.Clone()
+	for name, templates := range t.steps.headers {
+		values := make([]string, len(templates))
+		for i, tmpl := range templates {
+			if values[i], err = tmpl.expandWith(vars); err != nil {
+				return t, err
+			}
+		}
+		t.headers[name] = values
+	}
+	t.vars = vars
+	return t, nil
+}
+
+// dispatchSessions runs config.Concurrency virtual users, each running
+// config.Steps in order until config.Requests sessions have started or
+// config.Duration has elapsed. A session ends early at the first step that
+// fails or whose extraction does not match.
+func dispatchSessions(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result {
+	resultChan := make(chan Result, config.Concurrency)
+
+	var wg sync.WaitGroup
+	var started int64
+
+	deadline := time.Now().Add(config.Duration)
+
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if config.Stagger > 0 {
+				sleep(ctx, staggerDelay(config))
+			}
+			for ctx.Err() == nil {
+				if config.Duration > 0 {
+					if !time.Now().Before(deadline) {
+						return
+					}
+				} else if atomic.AddInt64(&started, 1) > int64(config.Requests) {
+					return
+				}
+				runSession(ctx, requestCtx, client, config, picker.targets, resultChan)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	return resultChan
+}
+
+func runSession(ctx, requestCtx context.Context, client *http.Client, config Config, steps []target, resultChan chan<- Result) {
+	vars := make(map[string]string)
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var result Result
+		rendered, err := step.render(vars)
+		if err != nil {
+			result = Result{Method: step.method, URL: step.url.raw, Endpoint: step.name, Start: time.Now(), Error: err}
+		} else {
+			result = sendRequest(requestCtx, client, config, rendered)
+		}
+		if result.Error == nil && config.SuccessCodes.Match(result.StatusCode) {
+			for _, extract := range step.steps.extract {
+				value, err := extract.apply(result.body)
+				if err != nil {
+					result.Error = err
+					break
+				}
+				vars[extract.Name] = value
+			}
+		}
+		result.body = nil
+		resultChan <- result
+
+		if result.Error != nil || !config.SuccessCodes.Match(result.StatusCode) {
+			return
+		}
+		if config.ThinkTime > 0 || config.ThinkJitter > 0 {
+			sleep(ctx, thinkTime(config))
+		}
+	}
+}
+
+func (e Extract) apply(body []byte) (string, error) {
+	if e.Regexp != nil {
+		match := e.Regexp.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("extract %s: body does not match %q", e.Name, e.Regexp)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("extract %s: %w", e.Name, err)
+	}
+	for _, key := range strings.Split(e.JSONPath, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return "", fmt.Errorf("extract %s: no %q in the response", e.Name, e.JSONPath)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("extract %s: no %q in the response", e.Name, e.JSONPath)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("extract %s: no %q in the response", e.Name, e.JSONPath)
+		}
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, _ := json.Marshal(value)
+	return string(data), nil
+}
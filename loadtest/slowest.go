@@ -0,0 +1,68 @@
+package loadtest
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// SlowRequest is one of the slowest requests kept for Config.TopSlow.
+type SlowRequest struct {
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Duration   time.Duration `json:"duration_ns"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// slowest keeps the n slowest requests seen in a min-heap, so that the
+// fastest of them is replaced first and memory stays bounded by n.
+type slowest struct {
+	n    int
+	heap slowHeap
+}
+
+func (s *slowest) add(result Result) {
+	if s.n <= 0 {
+		return
+	}
+	if len(s.heap) == s.n {
+		if result.Duration <= s.heap[0].Duration {
+			return
+		}
+		heap.Pop(&s.heap)
+	}
+	request := SlowRequest{
+		Method:     result.Method,
+		URL:        result.URL,
+		StatusCode: result.StatusCode,
+		Duration:   result.Duration,
+	}
+	if result.Error != nil {
+		request.Error = result.Error.Error()
+	}
+	heap.Push(&s.heap, request)
+}
+
+// requests returns the kept requests, slowest first.
+func (s *slowest) requests() []SlowRequest {
+	if len(s.heap) == 0 {
+		return nil
+	}
+	requests := append([]SlowRequest(nil), s.heap...)
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Duration > requests[j].Duration })
+	return requests
+}
+
+type slowHeap []SlowRequest
+
+func (h slowHeap) Len() int            { return len(h) }
+func (h slowHeap) Less(i, j int) bool  { return h[i].Duration < h[j].Duration }
+func (h slowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x interface{}) { *h = append(*h, x.(SlowRequest)) }
+func (h *slowHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
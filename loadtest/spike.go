@@ -0,0 +1,43 @@
+package loadtest
+
+import (
+	"errors"
+	"time"
+)
+
+// Spike is a load profile that ramps the number of requests in flight from
+// 1 up to Peak over RampUp, holds Peak for Hold and ramps back down to 1
+// over RampDown.
+type Spike struct {
+	Peak     int
+	RampUp   time.Duration
+	Hold     time.Duration
+	RampDown time.Duration
+}
+
+func (s Spike) duration() time.Duration {
+	return s.RampUp + s.Hold + s.RampDown
+}
+
+func (s Spike) validate() error {
+	if s.Peak <= 0 || s.RampUp < 0 || s.Hold < 0 || s.RampDown < 0 || s.duration() <= 0 {
+		return errors.New("loadtest: a spike needs a positive peak and duration")
+	}
+	return nil
+}
+
+// concurrencyAt returns the number of requests allowed in flight elapsed
+// into the spike.
+func (s Spike) concurrencyAt(elapsed time.Duration) int {
+	switch {
+	case elapsed < s.RampUp:
+		return 1 + int(float64(s.Peak-1)*elapsed.Seconds()/s.RampUp.Seconds())
+	case elapsed < s.RampUp+s.Hold:
+		return s.Peak
+	case elapsed < s.duration():
+		left := s.duration() - elapsed
+		return 1 + int(float64(s.Peak-1)*left.Seconds()/s.RampDown.Seconds())
+	default:
+		return 1
+	}
+}
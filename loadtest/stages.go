@@ -0,0 +1,169 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Stage is one step of a staged load profile. Rate and Concurrency override
+// the Config values for the stage when non-zero.
+type Stage struct {
+	Duration    time.Duration
+	Rate        float64
+	Concurrency int
+}
+
+// StageStats summarises the requests sent during one Stage.
+type StageStats struct {
+	Duration           time.Duration `json:"duration_ns"`
+	Rate               float64       `json:"rate"`
+	Concurrency        int           `json:"concurrency"`
+	Requests           int           `json:"requests"`
+	SuccessfulRequests int           `json:"successful_requests"`
+	FailedRequests     int           `json:"failed_requests"`
+	RequestsPerSecond  float64       `json:"requests_per_second"`
+	AverageTime        time.Duration `json:"average_time_ns"`
+	P95                time.Duration `json:"p95_ns"`
+
+	totalTime time.Duration
+	durations latencies
+}
+
+// stageConfig returns the config used to run stage.
+func stageConfig(config Config, stage Stage) Config {
+	c := config
+	c.Stages = nil
+	c.Requests = 0
+	c.Duration = stage.Duration
+	if stage.Rate > 0 {
+		c.Rate = stage.Rate
+	}
+	if stage.Concurrency > 0 {
+		c.Concurrency = stage.Concurrency
+	}
+	return c
+}
+
+// newStageStats returns the empty per-stage statistics for config.Stages.
+func newStageStats(config Config) []StageStats {
+	stats := make([]StageStats, len(config.Stages))
+	for i, stage := range config.Stages {
+		c := stageConfig(config, stage)
+		stats[i] = StageStats{
+			Duration:    stage.Duration,
+			Rate:        c.Rate,
+			Concurrency: c.Concurrency,
+			durations:   newLatencies(config.StreamingQuantiles),
+		}
+	}
+	return stats
+}
+
+// dispatchStages runs config.Stages one after another, tagging each Result
+// with the index of its stage.
+func dispatchStages(ctx, requestCtx context.Context, client *http.Client, config Config, picker *picker) <-chan Result {
+	resultChan := make(chan Result, config.Concurrency)
+
+	go func() {
+		defer close(resultChan)
+		for i, stage := range config.Stages {
+			c := stageConfig(config, stage)
+			if i > 0 {
+				// The workers are already spread out by the first stage.
+				c.Stagger = 0
+			}
+			for result := range dispatch(ctx, requestCtx, client, c, picker) {
+				result.Stage = i
+				resultChan <- result
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	return resultChan
+}
+
+// maxConcurrency is the highest concurrency of config and its stages. It
+// sizes the connection pool.
+func maxConcurrency(config Config) int {
+	n := config.Concurrency
+	for _, stage := range config.Stages {
+		if stage.Concurrency > n {
+			n = stage.Concurrency
+		}
+	}
+	return n
+}
+
+// kneeWindow is the number of most recent results over which the rolling
+// error rate is computed for knee point detection.
+const kneeWindow = 100
+
+// KneePoint records the load at which the rolling error rate first exceeded
+// Config.KneeErrorRate.
+type KneePoint struct {
+	// Elapsed is the time since the start of the test.
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Stage is the 1-based stage number, or 0 without stages.
+	Stage int `json:"stage,omitempty"`
+	// Rate and Concurrency are the configured load at that moment; during a
+	// ramp-up or spike Concurrency is the effective concurrency.
+	Rate              float64 `json:"rate"`
+	Concurrency       int     `json:"concurrency"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	ErrorRate         float64 `json:"error_rate"`
+}
+
+// kneeDetector tracks the rolling error rate of the last kneeWindow results.
+type kneeDetector struct {
+	failed []bool
+	times  []time.Time
+	next   int
+	count  int
+	errors int
+}
+
+// add records a result received at now and reports the rolling error rate,
+// in percent, and request rate once the window is full.
+func (k *kneeDetector) add(failed bool, now time.Time) (errorRate, rps float64, full bool) {
+	if k.failed == nil {
+		k.failed = make([]bool, kneeWindow)
+		k.times = make([]time.Time, kneeWindow)
+	}
+	if k.count == kneeWindow {
+		if k.failed[k.next] {
+			k.errors--
+		}
+	} else {
+		k.count++
+	}
+	k.failed[k.next] = failed
+	k.times[k.next] = now
+	if failed {
+		k.errors++
+	}
+	k.next = (k.next + 1) % kneeWindow
+
+	if k.count < kneeWindow {
+		return 0, 0, false
+	}
+	oldest := k.times[k.next]
+	errorRate = float64(k.errors) / kneeWindow * 100
+	if elapsed := now.Sub(oldest).Seconds(); elapsed > 0 {
+		rps = (kneeWindow - 1) / elapsed
+	}
+	return errorRate, rps, true
+}
+
+// loadAt returns the Rate, Concurrency and 1-based stage number in effect
+// for a result of the given stage received elapsed into the test.
+func loadAt(config Config, stage int, elapsed time.Duration) (float64, int, int) {
+	if len(config.Stages) > 0 {
+		c := stageConfig(config, config.Stages[stage])
+		return c.Rate, c.Concurrency, stage + 1
+	}
+	return config.Rate, activeConcurrency(config, elapsed), 0
+}
@@ -0,0 +1,55 @@
+package loadtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CodeRange is an inclusive range of HTTP status codes.
+type CodeRange struct {
+	Min, Max int
+}
+
+// StatusMatcher reports whether a status code counts as successful.
+type StatusMatcher []CodeRange
+
+// Match reports whether code falls within any of the ranges.
+func (m StatusMatcher) Match(code int) bool {
+	for _, r := range m {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStatusCodes parses a comma-separated list of status codes and
+// ranges such as "200-299,304".
+func ParseStatusCodes(value string) (StatusMatcher, error) {
+	var matcher StatusMatcher
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid success code %q", part)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil || to < from {
+				return nil, fmt.Errorf("invalid success code range %q", part)
+			}
+		}
+		matcher = append(matcher, CodeRange{Min: from, Max: to})
+	}
+	if len(matcher) == 0 {
+		return nil, fmt.Errorf("no success codes specified")
+	}
+	return matcher, nil
+}
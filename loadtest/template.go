@@ -0,0 +1,84 @@
+package loadtest
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const randstrAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// templateFuncs are the functions available in URL templates:
+//
+//	{{uuid}}           random version 4 UUID
+//	{{randint 1 1000}} random integer in [1, 1000]
+//	{{randstr 8}}      random alphanumeric string of length 8
+//	{{timestamp}}      current Unix time in seconds
+var templateFuncs = template.FuncMap{
+	"uuid":      randomUUID,
+	"randint":   randomInt,
+	"randstr":   randomString,
+	"timestamp": func() int64 { return time.Now().Unix() },
+}
+
+// urlTemplate is a configured URL that may contain template actions, which
+// are expanded for every request.
+type urlTemplate struct {
+	raw  string
+	tmpl *template.Template
+}
+
+func parseURLTemplates(urls []string) ([]urlTemplate, error) {
+	templates := make([]urlTemplate, len(urls))
+	for i, raw := range urls {
+		templates[i].raw = raw
+		if !strings.Contains(raw, "{{") {
+			continue
+		}
+		tmpl, err := template.New("url").Funcs(templateFuncs).Option("missingkey=error").Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("loadtest: invalid URL template %q: %w", raw, err)
+		}
+		templates[i].tmpl = tmpl
+	}
+	return templates, nil
+}
+
+// expandWith expands u with data, the variables of a session, available as
+// {{.name}}.
+func (u urlTemplate) expandWith(data map[string]string) (string, error) {
+	if u.tmpl == nil {
+		return u.raw, nil
+	}
+	var b strings.Builder
+	if err := u.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func randomUUID() string {
+	var b [16]byte
+	crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func randomInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randint: max %d is less than min %d", max, min)
+	}
+	return min + rand.Intn(max-min+1), nil
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = randstrAlphabet[rand.Intn(len(randstrAlphabet))]
+	}
+	return string(b)
+}
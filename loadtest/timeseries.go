@@ -0,0 +1,80 @@
+package loadtest
+
+import "time"
+
+// TimeSeriesPoint summarises the results received during one
+// Config.TimeSeriesInterval of the test.
+type TimeSeriesPoint struct {
+	// Elapsed is the start of the interval since the start of the test.
+	Elapsed  time.Duration `json:"elapsed_ns"`
+	Requests int           `json:"requests"`
+	// Errors counts the requests that failed or got a status outside
+	// Config.SuccessCodes.
+	Errors            int           `json:"errors"`
+	RequestsPerSecond float64       `json:"requests_per_second"`
+	AverageTime       time.Duration `json:"average_time_ns"`
+	P95               time.Duration `json:"p95_ns"`
+}
+
+// timeSeries buckets results by the interval in which they were received.
+// Results arrive in order, so only the response times of the last interval
+// are kept; the statistics of an interval are computed once the next one
+// starts.
+type timeSeries struct {
+	interval  time.Duration
+	start     time.Time
+	streaming bool
+	points    []TimeSeriesPoint
+
+	totalTime time.Duration
+	completed int
+	durations latencies
+}
+
+func (s *timeSeries) add(result Result, now time.Time, successCodes StatusMatcher) {
+	i := int(now.Sub(s.start) / s.interval)
+	for len(s.points) <= i {
+		s.close()
+		s.points = append(s.points, TimeSeriesPoint{Elapsed: time.Duration(len(s.points)) * s.interval})
+		s.totalTime, s.completed = 0, 0
+		s.durations = newLatencies(s.streaming)
+	}
+	point := &s.points[len(s.points)-1]
+	point.Requests++
+	if result.Error != nil || !successCodes.Match(result.StatusCode) {
+		point.Errors++
+	}
+	if result.Error == nil {
+		s.totalTime += result.Duration
+		s.completed++
+		s.durations.add(result.Duration)
+	}
+}
+
+// close computes the response times of the last interval.
+func (s *timeSeries) close() {
+	if len(s.points) == 0 {
+		return
+	}
+	point := &s.points[len(s.points)-1]
+	if s.completed > 0 {
+		point.AverageTime = s.totalTime / time.Duration(s.completed)
+	}
+	point.P95 = s.durations.percentile(95)
+	s.durations = latencies{}
+}
+
+// finish returns the points of a test that lasted total, computing their
+// rates. The last interval is cut short by the end of the test.
+func (s *timeSeries) finish(total time.Duration) []TimeSeriesPoint {
+	s.close()
+	for i := range s.points {
+		point := &s.points[i]
+		width := s.interval
+		if end := point.Elapsed + width; end > total && total > point.Elapsed {
+			width = total - point.Elapsed
+		}
+		point.RequestsPerSecond = float64(point.Requests) / width.Seconds()
+	}
+	return s.points
+}
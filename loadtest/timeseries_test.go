@@ -0,0 +1,40 @@
+package loadtest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeSeries(t *testing.T) {
+	start := time.Now()
+	series := timeSeries{interval: time.Second, start: start}
+	codes := StatusMatcher{{Min: 200, Max: 299}}
+	for _, r := range []struct {
+		at     time.Duration
+		status int
+		took   time.Duration
+	}{
+		{100 * time.Millisecond, http.StatusOK, 10 * time.Millisecond},
+		{500 * time.Millisecond, http.StatusInternalServerError, 30 * time.Millisecond},
+		{2200 * time.Millisecond, http.StatusOK, 20 * time.Millisecond},
+	} {
+		series.add(Result{StatusCode: r.status, Duration: r.took}, start.Add(r.at), codes)
+	}
+
+	points := series.finish(2500 * time.Millisecond)
+
+	want := []TimeSeriesPoint{
+		{Elapsed: 0, Requests: 2, Errors: 1, RequestsPerSecond: 2, AverageTime: 20 * time.Millisecond, P95: 30 * time.Millisecond},
+		{Elapsed: time.Second},
+		{Elapsed: 2 * time.Second, Requests: 1, RequestsPerSecond: 2, AverageTime: 20 * time.Millisecond, P95: 20 * time.Millisecond},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d: %+v", len(points), len(want), points)
+	}
+	for i := range want {
+		if points[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, points[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package loadtest
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing breaks a request down into its connection phases. Phases that did
+// not happen, such as DNS and connect on a reused connection, are zero.
+type Timing struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from sending the request to the first response byte,
+	// including any connection setup.
+	TTFB time.Duration
+	// GotConn is set once a connection was obtained for the request, and
+	// Reused when it was an idle keep-alive connection rather than a new one.
+	GotConn bool
+	Reused  bool
+}
+
+// tracer records a Timing through an httptrace.ClientTrace. Its callbacks
+// may run on other goroutines, e.g. when dialing several addresses at once.
+type tracer struct {
+	start time.Time
+	// firstByte, if set, is called when the first response byte arrives.
+	firstByte func()
+
+	mu                               sync.Mutex
+	dnsStart, connectStart, tlsStart time.Time
+	timing                           Timing
+}
+
+func (t *tracer) withTrace(ctx context.Context) context.Context {
+	t.start = time.Now()
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timing.DNS = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.timing.Connect = time.Since(t.connectStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timing.TLS = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.timing.GotConn = true
+			t.timing.Reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.timing.TTFB = time.Since(t.start)
+			t.mu.Unlock()
+			if t.firstByte != nil {
+				t.firstByte()
+			}
+		},
+	})
+}
+
+func (t *tracer) result() Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timing
+}
@@ -1,154 +1,1329 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"sync"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
 )
 
+const version = "0.1.0"
+
+// Exit statuses, so that scripts can tell a failed test from a mistake in
+// the command line.
+const (
+	// exitFailed reports a failed threshold, baseline regression or
+	// -stop-on-error.
+	exitFailed = 1
+	// exitUsage reports invalid flags or input files, as the flag package
+	// does for flags it cannot parse.
+	exitUsage = 2
+	// exitInternal reports a failure unrelated to the configuration, such
+	// as a host that could not be resolved or a port that could not be
+	// served on, which may succeed when retried.
+	exitInternal = 3
+)
+
+// lookupExitCode returns exitInternal for a failure to resolve or reach a
+// host and exitUsage for any other error.
+func lookupExitCode(err error) int {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, loadtest.ErrPreflight) {
+		return exitInternal
+	}
+	return exitUsage
+}
+
+var validMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
 
-type Result struct {
-	StatusCode int
-	Duration   time.Duration
-	Error      error
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
 }
 
-type Report struct {
-	TotalRequests      int
-	TotalDuration      time.Duration
-	StatusCodes        map[int]int
-	SuccessfulRequests int
-	FailedRequests     int
-	AverageTime        time.Duration
-	MinTime            time.Duration
-	MaxTime            time.Duration
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
 }
 
 func main() {
-	url := flag.String("url", "", "URL of the service to test")
+	targetURL := flag.String("url", "", "URL of the service to test, or - to read URLs from stdin")
+	urlsFile := flag.String("urls-file", "", "Path to a file with one URL per line to test in round-robin order, or - for stdin")
+	sessionPath := flag.String("session", "", "Path to a JSON file of steps each virtual user runs in order, instead of -url or -urls-file")
+	shuffle := flag.Bool("shuffle", false, "Pick a random URL from -urls-file for each request instead of round-robin, counting repeated URLs once")
+	weighted := flag.Bool("weighted", false, "Like -shuffle, but repeated URLs in -urls-file are picked proportionally more often")
+	seed := flag.Int64("seed", 0, "Seed for the random choice of URLs and scenario endpoints, for reproducible runs (0 picks a random seed)")
+	mix := flag.String("mix", "", "Weighted mix of HTTP methods sent to -url, e.g. GET:80,POST:20; POST, PUT and PATCH send the body")
+	scenarioPath := flag.String("scenario", "", "Path to a JSON file of weighted endpoints to request instead of -url or -urls-file")
 	requests := flag.Int("requests", 100, "Total number of requests")
 	concurrency := flag.Int("concurrency", 10, "Number of concurrent requests")
+	method := flag.String("method", "GET", "HTTP method to use (GET, POST, PUT, PATCH, DELETE, HEAD, OPTIONS)")
+	body := flag.String("body", "", "Request body to send")
+	bodyFile := flag.String("body-file", "", "Path to a file containing the request body")
+	streamBody := flag.String("stream-body", "", "Path to a file streamed as the request body with chunked transfer encoding, read through a fresh handle by each request instead of held in memory")
+	bodyJSONL := flag.String("body-file-jsonl", "", "Path to a JSON lines file whose lines are used in turn as request bodies, cycling when there are more requests than lines")
+	var formValues, formFiles headerFlags
+	flag.Var(&formValues, "form", "Form field in the form key=value, sent URL-encoded (can be repeated)")
+	flag.Var(&formFiles, "form-file", "File to upload in the form field=path, sent as multipart/form-data with any -form fields (can be repeated)")
+	var headerValues headerFlags
+	flag.Var(&headerValues, "H", "Custom header in the form \"Name: value\" (can be repeated)")
+	var randomHeaderValues headerFlags
+	flag.Var(&randomHeaderValues, "H-random", "Header in the form \"Name: @file\" whose value is picked at random for each request from the lines of file (can be repeated)")
+	user := flag.String("user", "", "Basic authentication credentials in the form user:pass")
+	userAgent := flag.String("user-agent", "load-test-go/"+version, "User-Agent header sent with every request (an explicit -H User-Agent takes precedence)")
+	token := flag.String("token", "", "Bearer token sent in the Authorization header")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout for each request")
+	ttfbTimeout := flag.Duration("ttfb-timeout", 0, "Fail requests whose first response byte does not arrive within this time, even if -timeout has not elapsed (0 means no limit)")
+	stagesValue := flag.String("stages", "", "Run a sequence of load levels instead of -requests or -duration, as comma-separated duration:rate[:concurrency] steps (e.g. 30s:10,30s:50,30s:100)")
+	adaptiveP95 := flag.Duration("adaptive", 0, "Vary the requests in flight up to -concurrency to find the highest throughput whose p95 stays within this target (e.g. 200ms)")
+	adaptiveInterval := flag.Duration("adaptive-interval", 5*time.Second, "How often -adaptive adjusts the concurrency")
+	adaptiveErrorRate := flag.Float64("adaptive-max-error-rate", 1, "Percentage of failed requests in an interval beyond which -adaptive backs off")
+	spikeValue := flag.String("spike", "", "Run a spike test instead of -requests or -duration, as peak:ramp-up:hold:ramp-down (e.g. 200:10s:30s:10s ramps to 200 concurrent requests over 10s, holds for 30s and ramps down over 10s)")
+	streamingQuantiles := flag.Bool("streaming-quantiles", false, "Estimate percentiles within 1% from a fixed-size sketch instead of keeping every response time, for very large runs")
+	topSlow := flag.Int("top-slow", 0, "List the N slowest requests with their URL and status in the report")
+	slo := flag.Duration("slo", 0, "Count responses slower than this as SLO violations and report the SLO compliance percentage")
+	kneeErrorRate := flag.Float64("knee-error-rate", 0, "Report the load at which the rolling error rate over the last 100 requests first exceeds this percentage")
+	repeat := flag.Int("repeat", 1, "Run the whole test this many times and report the combined results with a per-run breakdown")
+	cooldown := flag.Duration("cooldown", 0, "Pause between -repeat runs (e.g. 10s), with idle connections closed")
+	drainTimeout := flag.Duration("drain-timeout", 0, "On Ctrl+C, stop sending new requests but let those in flight finish for up to this long before cancelling them")
+	maxDuration := flag.Duration("max-duration", 0, "Hard limit on the total run time, cancelling in-flight requests when reached (0 means no limit)")
+	duration := flag.Duration("duration", 0, "Run for this long instead of a fixed number of requests (e.g. 30s)")
+	rampUp := flag.Duration("ramp-up", 0, "Linearly increase concurrency from 1 to -concurrency over this period")
+	retries := flag.Int("retries", 0, "Retry failed requests up to this many times")
+	retryBackoff := flag.Duration("retry-backoff", 100*time.Millisecond, "Wait before the first retry, doubling after each attempt")
+	retry5xx := flag.Bool("retry-5xx", true, "Also retry requests that receive a 5xx response (4xx responses are never retried)")
+	thinkTime := flag.Duration("think-time", 0, "Pause each worker for this long between its requests")
+	thinkJitter := flag.Duration("think-jitter", 0, "Randomise -think-time by up to plus or minus this amount")
+	stagger := flag.Duration("stagger", 0, "Delay each worker's first request by a random offset of up to this long, so that workers do not send in step (e.g. -concurrency divided by -rate)")
+	rate := flag.Float64("rate", 0, "Maximum requests per second (0 means unlimited)")
+	rateJitter := flag.String("rate-jitter", "", "Randomize the intervals between requests at -rate, keeping the average: a fraction such as 0.2 for up to 20% either way, or \"poisson\" for exponentially distributed intervals")
+	openModel := flag.Bool("open-model", false, "Start requests at -rate regardless of whether earlier ones have completed, with at most -concurrency in flight")
+	maxInFlight := flag.Int("max-inflight", 0, "Cap the requests in flight across every mode, to protect the load generator itself (0 means no cap beyond -concurrency)")
+	maxInFlightDrop := flag.Bool("max-inflight-drop", false, "With -open-model, drop requests that would exceed -max-inflight instead of waiting for a slot, and report how many were dropped")
+	successCodes := flag.String("success-codes", "200-299", "Comma-separated status codes or ranges counted as successful (e.g. 200-299,304)")
+	noPreflight := flag.Bool("no-preflight", false, "Do not send a first request to abort early when the host does not resolve, refuses connections or fails the TLS handshake")
+	warmup := flag.Int("warmup", 0, "Number of warmup requests to send before measuring (excluded from the report)")
+	ipVersion := flag.Int("ip-version", 0, "Connect over IPv4 (4) or IPv6 (6) only; by default any address of the host is used")
+	noDNSCache := flag.Bool("no-dns-cache", false, "Resolve hosts for every new connection instead of once per run")
+	disableKeepAlive := flag.Bool("disable-keepalive", false, "Open a new connection for every request")
+	cookies := flag.Bool("cookies", false, "Keep cookies set by responses and send them on later requests")
+	host := flag.String("host", "", "Host header to send instead of the URL's host, e.g. to reach a virtual host or canary backend by IP (also used for TLS SNI)")
+	unixSocket := flag.String("unix-socket", "", "Connect to this Unix domain socket instead of the URL's host, which is then only used for the Host header")
+	proxy := flag.String("proxy", "", "Proxy URL to send requests through (http, https or socks5)")
+	respectProxyEnv := flag.Bool("respect-proxy-env", false, "Use HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment when -proxy is not set")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	certFile := flag.String("cert", "", "Path to a PEM client certificate for mutual TLS")
+	keyFile := flag.String("key", "", "Path to the PEM private key for -cert")
+	caCertFile := flag.String("cacert", "", "Path to a PEM CA bundle used to verify the server")
+	tlsMinVersion := flag.String("tls-min-version", "", "Lowest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default 1.2)")
+	tlsMaxVersion := flag.String("tls-max-version", "", "Highest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default 1.3)")
+	tlsCiphers := flag.String("tls-ciphers", "", "Comma-separated TLS 1.2 and earlier cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	maxRedirects := flag.Int("max-redirects", 10, "Maximum number of redirects to follow (0 records the redirect response itself)")
+	expectBody := flag.String("expect-body", "", "Fail responses whose body does not contain this substring")
+	expectRegex := flag.String("expect-regex", "", "Fail responses whose body does not match this regular expression")
+	expectJSON := flag.Bool("expect-json", false, "Fail responses whose body is not valid JSON")
+	jsonSchemaFile := flag.String("json-schema", "", "Fail responses whose body does not match the JSON Schema in this file (implies -expect-json)")
+	noBodyRead := flag.Bool("no-body-read", false, "Close each response once its headers arrive without reading the body; byte counts are then zero and connections of large responses are not reused")
+	buckets := flag.String("buckets", "", "Comma-separated latency histogram bucket bounds (e.g. 10ms,50ms,100ms,1s)")
+	http2 := flag.Bool("http2", false, "Force HTTP/2 (requests answered over another protocol count as failed)")
+	http1 := flag.Bool("http1", false, "Force HTTP/1.1")
+	csvFile := flag.String("csv", "", "Write raw per-request results to this CSV file")
+	timeSeriesFile := flag.String("timeseries", "", "Write the requests, errors, RPS, average and p95 response time of each -timeseries-interval of the run to this CSV file")
+	timeSeriesInterval := flag.Duration("timeseries-interval", time.Second, "Length of the intervals of -timeseries; also adds the time series to JSON reports on its own")
+	verbose := flag.Bool("verbose", false, "Log each request to stderr (first 1000 requests only)")
+	verboseHeaders := flag.Bool("verbose-headers", false, "Like -verbose, and also log response headers")
+	interval := flag.Duration("interval", 0, "Print interim stats (RPS, error rate, p95) at this interval")
+	quiet := flag.Bool("quiet", false, "Do not show the startup banner or live progress, only the report")
+	stopOnError := flag.Bool("stop-on-error", false, "Stop at the first request that errors or gets a non-success status, and exit with status 1")
+	maxErrorRate := flag.Float64("max-error-rate", -1, "Exit with status 1 if the percentage of unsuccessful requests exceeds this (negative disables)")
+	maxP95 := flag.Duration("max-p95", 0, "Exit with status 1 if the p95 response time exceeds this")
+	baselinePath := flag.String("baseline", "", "Compare the results with a report saved with -output json=path and exit with status 1 if they regressed beyond -regression-tolerance")
+	regressionTolerance := flag.Float64("regression-tolerance", 10, "Percentage by which response times may grow or the request rate drop, and percentage points by which the error rate may grow, before -baseline reports a regression")
+	minRPS := flag.Float64("min-rps", 0, "Exit with status 1 if requests per second fall below this")
+	rateTolerance := flag.Float64("rate-tolerance", 10, "Percentage by which the achieved rate may fall short of -rate before the report warns about it")
+	strictRate := flag.Bool("strict-rate", false, "Exit with status 1 if the achieved rate falls short of -rate by more than -rate-tolerance")
+	prometheusFile := flag.String("prometheus", "", "Write the results in Prometheus text format to this file (- for stdout)")
+	metricsPort := flag.Int("metrics-port", 0, "After the test, serve the results in Prometheus text format on this port at /metrics until interrupted")
+	noColor := flag.Bool("no-color", false, "Disable colored report output (also disabled by the NO_COLOR environment variable)")
+	var trackHeaders headerFlags
+	flag.Var(&trackHeaders, "track-header", "Response header whose values are counted into a distribution in the report, e.g. X-Cache (can be repeated)")
+	var outputValues headerFlags
+	flag.Var(&outputValues, "output", "Report format, text, json, prometheus or summary, written to stdout or to a file with format=path (can be repeated; default text)")
+
+	label := flag.String("label", "", "Label recorded in the report and CSV rows to tell stored results apart, e.g. a scenario name")
+	var tagValues headerFlags
+	flag.Var(&tagValues, "tag", "Metadata in the form key=value recorded in the report and CSV rows, e.g. env=staging (can be repeated)")
+	every := flag.Duration("every", 0, "Run the test again at this interval (e.g. 5m) until interrupted, printing a timestamped summary line per run")
+	dryRun := flag.Bool("dry-run", false, "Print the first request that would be sent and resolve its host, then exit without running the test")
+	configFile := flag.String("config", "", "Path to a JSON file with default values for any of these flags")
 
 	flag.Parse()
 
-	if *url == "" {
-		fmt.Println("Error: URL is required")
+	commandLine := setFlags()
+	if *configFile != "" {
+		if err := applyConfigFile(*configFile, commandLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	configured := setFlags()
+
+	if *targetURL == "" && *urlsFile == "" && *scenarioPath == "" && *sessionPath == "" && !isTerminal(os.Stdin) {
+		// URLs piped in without a URL flag, as in cat urls.txt | loadtest.
+		*urlsFile = "-"
+	}
+	if *targetURL == "" && *urlsFile == "" && *scenarioPath == "" && *sessionPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: URL is required")
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	if *requests <= 0 {
-		fmt.Println("Error: Number of requests must be greater than 0")
-		os.Exit(1)
+	if *targetURL != "" && *urlsFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -url and -urls-file can be specified")
+		os.Exit(exitUsage)
+	}
+	if *targetURL == "-" {
+		*targetURL, *urlsFile = "", "-"
 	}
 
-	if *concurrency <= 0 || *concurrency > *requests {
-		fmt.Println("Error: Concurrency must be greater than 0 and less than or equal to the number of requests")
-		os.Exit(1)
+	sources := 0
+	for _, path := range []string{*urlsFile, *scenarioPath, *sessionPath} {
+		if path != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -urls-file, -scenario and -session can be specified")
+		os.Exit(exitUsage)
 	}
 
-	fmt.Printf("Starting load test for %s\n", *url)
-	fmt.Printf("Total requests: %d\n", *requests)
-	fmt.Printf("Concurrency level: %d\n\n", *concurrency)
-	
-	report := runLoadTest(*url, *requests, *concurrency)
+	if *shuffle && *weighted {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -shuffle and -weighted can be specified")
+		os.Exit(exitUsage)
+	}
+	urlOrder := ""
+	if *shuffle {
+		urlOrder = "shuffle"
+	} else if *weighted {
+		urlOrder = "weighted"
+	}
 
-	printReport(report)
-}
+	envValues := []*string{targetURL, body, token, user}
+	for i := range headerValues {
+		envValues = append(envValues, &headerValues[i])
+	}
+	for i := range formValues {
+		envValues = append(envValues, &formValues[i])
+	}
+	if err := expandEnvValues(envValues...); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	urls := []string{*targetURL}
+	if *urlsFile != "" {
+		var err error
+		urls, err = readURLs(*urlsFile)
+		if err != nil && *urlsFile == "-" {
+			fmt.Fprintf(os.Stderr, "Error: Could not read URLs from stdin: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read URLs file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
 
-func runLoadTest(url string, totalRequests, concurrency int) Report {
-	resultChan := make(chan Result, totalRequests)
-	
-	var wg sync.WaitGroup
+	if *duration < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Duration must not be negative")
+		os.Exit(exitUsage)
+	}
 
-	semaphore := make(chan struct{}, concurrency)
+	// A config file's requests only apply when nothing else bounds the run.
+	requestsSet := commandLine["requests"]
+	if *duration > 0 && requestsSet {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -requests and -duration can be specified")
+		os.Exit(exitUsage)
+	}
 
-	startTime := time.Now()
+	var stages []loadtest.Stage
+	if *stagesValue != "" {
+		if *duration > 0 || requestsSet {
+			fmt.Fprintln(os.Stderr, "Error: -stages cannot be combined with -requests or -duration")
+			os.Exit(exitUsage)
+		}
+		var err error
+		stages, err = parseStages(*stagesValue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
 
-	for i := 0; i < totalRequests; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	var spike *loadtest.Spike
+	if *spikeValue != "" {
+		if *duration > 0 || requestsSet || len(stages) > 0 || *rampUp > 0 || *openModel {
+			fmt.Fprintln(os.Stderr, "Error: -spike cannot be combined with -requests, -duration, -stages, -ramp-up or -open-model")
+			os.Exit(exitUsage)
+		}
+		var err error
+		spike, err = parseSpike(*spikeValue)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		*concurrency = spike.Peak
+	}
 
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-			
-			start := time.Now()
-			resp, err := http.Get(url)
-			duration := time.Since(start)
+	var adaptive *loadtest.Adaptive
+	if *adaptiveP95 != 0 {
+		if *rate > 0 || *openModel || len(stages) > 0 || spike != nil || *rampUp > 0 || *sessionPath != "" || *repeat > 1 {
+			fmt.Fprintln(os.Stderr, "Error: -adaptive cannot be combined with -rate, -open-model, -stages, -spike, -ramp-up, -session or -repeat")
+			os.Exit(exitUsage)
+		}
+		if *adaptiveP95 < 0 || *adaptiveInterval <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: -adaptive and -adaptive-interval must be positive")
+			os.Exit(exitUsage)
+		}
+		if *adaptiveErrorRate < 0 || *adaptiveErrorRate >= 100 {
+			fmt.Fprintln(os.Stderr, "Error: -adaptive-max-error-rate must be between 0 and 100")
+			os.Exit(exitUsage)
+		}
+		adaptive = &loadtest.Adaptive{TargetP95: *adaptiveP95, Interval: *adaptiveInterval, MaxErrorRate: *adaptiveErrorRate}
+	}
 
-			result := Result{
-				Duration: duration,
-				Error:    err,
-			}
+	if *duration == 0 && len(stages) == 0 && spike == nil && *requests <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: Number of requests must be greater than 0")
+		os.Exit(exitUsage)
+	}
+
+	if *concurrency <= 0 || (*duration == 0 && len(stages) == 0 && spike == nil && *concurrency > *requests) {
+		fmt.Fprintln(os.Stderr, "Error: Concurrency must be greater than 0 and less than or equal to the number of requests")
+		os.Exit(exitUsage)
+	}
+
+	form := len(formValues) > 0 || len(formFiles) > 0
+	// A method from the config file counts as much as one on the command
+	// line, both for the form default and for the -mix conflict.
+	methodSet := configured["method"]
+	if form && !methodSet {
+		*method = http.MethodPost
+	}
+
+	*method = strings.ToUpper(*method)
+	if !validMethods[*method] {
+		fmt.Fprintf(os.Stderr, "Error: Unsupported HTTP method %q\n", *method)
+		os.Exit(exitUsage)
+	}
+
+	if *body != "" && *bodyFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -body and -body-file can be specified")
+		os.Exit(exitUsage)
+	}
+	if *bodyJSONL != "" && (form || *body != "" || *bodyFile != "" || *scenarioPath != "" || *sessionPath != "") {
+		fmt.Fprintln(os.Stderr, "Error: -body-file-jsonl cannot be combined with -body, -body-file, -form, -form-file, -scenario or -session")
+		os.Exit(exitUsage)
+	}
+	if *streamBody != "" {
+		if form || *body != "" || *bodyFile != "" || *bodyJSONL != "" || *scenarioPath != "" || *sessionPath != "" || *mix != "" {
+			fmt.Fprintln(os.Stderr, "Error: -stream-body cannot be combined with -body, -body-file, -body-file-jsonl, -form, -form-file, -scenario, -session or -mix")
+			os.Exit(exitUsage)
+		}
+		file, err := os.Open(*streamBody)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read body file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		file.Close()
+	}
+	if form && (*body != "" || *bodyFile != "") {
+		fmt.Fprintln(os.Stderr, "Error: -form and -form-file cannot be combined with -body or -body-file")
+		os.Exit(exitUsage)
+	}
+
+	if *mix != "" {
+		if *urlsFile != "" || *scenarioPath != "" || *sessionPath != "" || *bodyJSONL != "" || methodSet {
+			fmt.Fprintln(os.Stderr, "Error: -mix cannot be combined with -urls-file, -scenario, -session, -body-file-jsonl or -method")
+			os.Exit(exitUsage)
+		}
+	}
+
+	var endpoints []loadtest.Endpoint
+	if *scenarioPath != "" {
+		if form || *body != "" || *bodyFile != "" {
+			fmt.Fprintln(os.Stderr, "Error: -scenario cannot be combined with -body, -body-file, -form or -form-file")
+			os.Exit(exitUsage)
+		}
+		var err error
+		endpoints, err = readScenario(*scenarioPath, *targetURL, *method)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read scenario file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		urls = nil
+	}
+
+	var steps []loadtest.Step
+	if *sessionPath != "" {
+		if form || *body != "" || *bodyFile != "" {
+			fmt.Fprintln(os.Stderr, "Error: -session cannot be combined with -body, -body-file, -form or -form-file")
+			os.Exit(exitUsage)
+		}
+		if *rate > 0 || *openModel || *stagesValue != "" || spike != nil || *rampUp > 0 {
+			fmt.Fprintln(os.Stderr, "Error: -session cannot be combined with -rate, -open-model, -stages, -spike or -ramp-up")
+			os.Exit(exitUsage)
+		}
+		var err error
+		steps, err = readSession(*sessionPath, *targetURL, *method)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read session file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		urls = nil
+	}
+
+	for _, u := range urls {
+		if err := validateURL(u); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, e := range endpoints {
+		if err := validateURL(e.URL); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, s := range steps {
+		if err := validateURL(s.URL); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	payload := []byte(*body)
+	if *bodyFile != "" {
+		data, err := os.ReadFile(*bodyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read body file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		payload = data
+	}
+
+	var bodies [][]byte
+	if *bodyJSONL != "" {
+		var err error
+		bodies, err = readJSONLines(*bodyJSONL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read body file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
 
-			if err == nil {
-				result.StatusCode = resp.StatusCode
-				resp.Body.Close()
+	headers, err := parseHeaders(headerValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if len(bodies) > 0 && headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", "application/json")
+	}
+
+	randomHeaders, err := readRandomHeaders(randomHeaderValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if form {
+		var contentType string
+		payload, contentType, err = encodeForm(formValues, formFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if headers.Get("Content-Type") == "" {
+			headers.Set("Content-Type", contentType)
+		}
+	}
+
+	if *mix != "" {
+		endpoints, err = parseMix(*mix, urls[0], payload)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		urls = nil
+	}
+
+	// Go ignores a Host entry in the header map, so -H Host works like -host.
+	if headers.Get("Host") != "" {
+		if *host == "" {
+			*host = headers.Get("Host")
+		}
+		headers.Del("Host")
+	}
+
+	if headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", *userAgent)
+	}
+
+	var username, password string
+	if *user != "" {
+		var ok bool
+		username, password, ok = strings.Cut(*user, ":")
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Error: -user must be in the form user:pass")
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *token != "" {
+		if headers.Get("Authorization") != "" || *user != "" {
+			fmt.Fprintln(os.Stderr, "Error: -token cannot be combined with -user or an explicit Authorization header")
+			os.Exit(exitUsage)
+		}
+		headers.Set("Authorization", "Bearer "+*token)
+	}
+
+	if *slo < 0 {
+		fmt.Fprintln(os.Stderr, "Error: SLO must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *kneeErrorRate < 0 || *kneeErrorRate >= 100 {
+		fmt.Fprintln(os.Stderr, "Error: Knee error rate must be between 0 and 100")
+		os.Exit(exitUsage)
+	}
+
+	if *repeat < 1 {
+		fmt.Fprintln(os.Stderr, "Error: Number of runs must be at least 1")
+		os.Exit(exitUsage)
+	}
+	if *cooldown < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Cooldown must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *drainTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Drain timeout must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *maxDuration < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Maximum duration must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *timeout <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: Timeout must be greater than 0")
+		os.Exit(exitUsage)
+	}
+
+	var jitter float64
+	poisson := false
+	if *rateJitter != "" {
+		if *rate <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: -rate-jitter requires -rate")
+			os.Exit(exitUsage)
+		}
+		if *rateJitter == "poisson" {
+			poisson = true
+		} else {
+			var err error
+			jitter, err = strconv.ParseFloat(*rateJitter, 64)
+			if err != nil || jitter <= 0 || jitter > 1 {
+				fmt.Fprintln(os.Stderr, "Error: Rate jitter must be \"poisson\" or a fraction between 0 and 1")
+				os.Exit(exitUsage)
 			}
+		}
+	}
+
+	if *rateTolerance < 0 || *rateTolerance >= 100 {
+		fmt.Fprintln(os.Stderr, "Error: Rate tolerance must be between 0 and 100")
+		os.Exit(exitUsage)
+	}
+	if *strictRate && *rate <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -strict-rate requires -rate")
+		os.Exit(exitUsage)
+	}
+
+	var baseline loadtest.Report
+	if *baselinePath != "" {
+		if *regressionTolerance < 0 {
+			fmt.Fprintln(os.Stderr, "Error: Regression tolerance must not be negative")
+			os.Exit(exitUsage)
+		}
+		var err error
+		baseline, err = readBaseline(*baselinePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read baseline: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *topSlow < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Number of slowest requests must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *maxInFlight < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Maximum in flight must not be negative")
+		os.Exit(exitUsage)
+	}
+	if *maxInFlightDrop && (*maxInFlight == 0 || !*openModel) {
+		fmt.Fprintln(os.Stderr, "Error: -max-inflight-drop requires -max-inflight and -open-model")
+		os.Exit(exitUsage)
+	}
+
+	if *ttfbTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: TTFB timeout must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *warmup < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Number of warmup requests must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *maxRedirects < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Maximum redirects must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	var proxyURL *url.URL
+	if *proxy != "" {
+		var err error
+		proxyURL, err = url.Parse(*proxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid proxy URL: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			fmt.Fprintf(os.Stderr, "Error: Unsupported proxy scheme %q, expected http, https or socks5\n", proxyURL.Scheme)
+			os.Exit(exitUsage)
+		}
+		if proxyURL.Host == "" {
+			fmt.Fprintln(os.Stderr, "Error: Proxy URL must include a host")
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *unixSocket != "" {
+		if *proxy != "" {
+			fmt.Fprintln(os.Stderr, "Error: -unix-socket cannot be combined with -proxy")
+			os.Exit(exitUsage)
+		}
+		info, err := os.Stat(*unixSocket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not use Unix socket: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			fmt.Fprintf(os.Stderr, "Error: %s is not a Unix socket\n", *unixSocket)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *ipVersion != 0 {
+		if *ipVersion != 4 && *ipVersion != 6 {
+			fmt.Fprintln(os.Stderr, "Error: -ip-version must be 4 or 6")
+			os.Exit(exitUsage)
+		}
+		if *unixSocket != "" {
+			fmt.Fprintln(os.Stderr, "Error: -ip-version cannot be combined with -unix-socket")
+			os.Exit(exitUsage)
+		}
+	}
+
+	if (*certFile == "") != (*keyFile == "") {
+		fmt.Fprintln(os.Stderr, "Error: -cert and -key must be specified together")
+		os.Exit(exitUsage)
+	}
+
+	var certificates []tls.Certificate
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not load client certificate: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		certificates = []tls.Certificate{cert}
+	}
+
+	var rootCAs *x509.CertPool
+	if *caCertFile != "" {
+		data, err := os.ReadFile(*caCertFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read CA certificate: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(data) {
+			fmt.Fprintf(os.Stderr, "Error: No valid certificates found in %s\n", *caCertFile)
+			os.Exit(exitUsage)
+		}
+	}
+
+	minVersion, err := parseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -tls-min-version: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	maxVersion, err := parseTLSVersion(*tlsMaxVersion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: -tls-max-version: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		fmt.Fprintln(os.Stderr, "Error: -tls-min-version must not be above -tls-max-version")
+		os.Exit(exitUsage)
+	}
+	var cipherSuites []uint16
+	if *tlsCiphers != "" {
+		if minVersion == tls.VersionTLS13 {
+			fmt.Fprintln(os.Stderr, "Error: -tls-ciphers has no effect with -tls-min-version 1.3, whose cipher suites cannot be configured")
+			os.Exit(exitUsage)
+		}
+		if cipherSuites, err = parseCipherSuites(*tlsCiphers); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -tls-ciphers: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *noBodyRead && (*expectBody != "" || *expectRegex != "" || *expectJSON || *jsonSchemaFile != "") {
+		fmt.Fprintln(os.Stderr, "Error: -no-body-read cannot be combined with -expect-body, -expect-regex, -expect-json or -json-schema")
+		os.Exit(exitUsage)
+	}
+
+	var bodyRegex *regexp.Regexp
+	if *expectRegex != "" {
+		var err error
+		bodyRegex, err = regexp.Compile(*expectRegex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid -expect-regex: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	var jsonSchema *loadtest.Schema
+	if *jsonSchemaFile != "" {
+		data, err := os.ReadFile(*jsonSchemaFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not read JSON schema: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		jsonSchema, err = loadtest.ParseSchema(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	var bucketBounds []time.Duration
+	if *buckets != "" {
+		var err error
+		bucketBounds, err = parseBuckets(*buckets)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *http1 && *http2 {
+		fmt.Fprintln(os.Stderr, "Error: Only one of -http1 and -http2 can be specified")
+		os.Exit(exitUsage)
+	}
+
+	httpVersion := ""
+	if *http1 {
+		httpVersion = "1.1"
+	} else if *http2 {
+		httpVersion = "2"
+	}
+
+	if *rampUp < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Ramp-up must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *retries < 0 || *retryBackoff < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Retries and retry backoff must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *thinkTime < 0 || *thinkJitter < 0 || *stagger < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Think time, jitter and stagger must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *interval < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Interval must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *rate < 0 {
+		fmt.Fprintln(os.Stderr, "Error: Rate must not be negative")
+		os.Exit(exitUsage)
+	}
+
+	if *openModel {
+		if *rate == 0 && len(stages) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: -open-model requires -rate")
+			os.Exit(exitUsage)
+		}
+		if *rampUp > 0 || *thinkTime > 0 || *thinkJitter > 0 || *stagger > 0 {
+			fmt.Fprintln(os.Stderr, "Error: -open-model cannot be combined with -ramp-up, -think-time, -think-jitter or -stagger")
+			os.Exit(exitUsage)
+		}
+	}
+
+	matcher, err := loadtest.ParseStatusCodes(*successCodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if *metricsPort < 0 || *metricsPort > 65535 {
+		fmt.Fprintln(os.Stderr, "Error: Metrics port must be between 1 and 65535")
+		os.Exit(exitUsage)
+	}
+
+	var tags map[string]string
+	for _, value := range tagValues {
+		key, tagValue, ok := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "Error: Invalid tag %q, expected key=value\n", value)
+			os.Exit(exitUsage)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = tagValue
+	}
+
+	if *every < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -every must not be negative")
+		os.Exit(exitUsage)
+	}
+	if *every > 0 {
+		if *csvFile != "" || *timeSeriesFile != "" || *metricsPort > 0 || *baselinePath != "" {
+			fmt.Fprintln(os.Stderr, "Error: -every cannot be combined with -csv, -timeseries, -metrics-port or -baseline")
+			os.Exit(exitUsage)
+		}
+		if len(outputValues) == 0 {
+			outputValues = headerFlags{"summary"}
+		}
+	}
+
+	outputs, err := openOutputs(outputValues)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	defer closeOutputs(outputs)
+
+	if *timeSeriesInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -timeseries-interval must be positive")
+		os.Exit(exitUsage)
+	}
+	// The time series is only recorded when asked for, with either flag.
+	var seriesInterval time.Duration
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "timeseries" || f.Name == "timeseries-interval" {
+			seriesInterval = *timeSeriesInterval
+		}
+	})
+
+	config := loadtest.Config{
+		URLs:                 urls,
+		URLOrder:             urlOrder,
+		Seed:                 *seed,
+		Endpoints:            endpoints,
+		Steps:                steps,
+		Method:               *method,
+		Body:                 payload,
+		Bodies:               bodies,
+		BodyFile:             *streamBody,
+		Headers:              headers,
+		RandomHeaders:        randomHeaders,
+		Username:             username,
+		Password:             password,
+		Host:                 *host,
+		Requests:             *requests,
+		Concurrency:          *concurrency,
+		Timeout:              *timeout,
+		TTFBTimeout:          *ttfbTimeout,
+		Duration:             *duration,
+		Stages:               stages,
+		Spike:                spike,
+		Adaptive:             adaptive,
+		KneeErrorRate:        *kneeErrorRate,
+		TopSlow:              *topSlow,
+		StreamingQuantiles:   *streamingQuantiles,
+		SLO:                  *slo,
+		MaxDuration:          *maxDuration,
+		Repeat:               *repeat,
+		Cooldown:             *cooldown,
+		DrainTimeout:         *drainTimeout,
+		Rate:                 *rate,
+		RateJitter:           jitter,
+		PoissonArrivals:      poisson,
+		OpenModel:            *openModel,
+		MaxInFlight:          *maxInFlight,
+		DropAtMaxInFlight:    *maxInFlightDrop,
+		SuccessCodes:         matcher,
+		Interval:             *interval,
+		TimeSeriesInterval:   seriesInterval,
+		VerboseHeaders:       *verboseHeaders,
+		TrackHeaders:         trackHeaders,
+		Label:                *label,
+		Tags:                 tags,
+		Warmup:               *warmup,
+		Preflight:            !*noPreflight,
+		StopOnError:          *stopOnError,
+		Retries:              *retries,
+		RetryBackoff:         *retryBackoff,
+		RetryServerErrors:    *retry5xx,
+		ThinkTime:            *thinkTime,
+		ThinkJitter:          *thinkJitter,
+		Stagger:              *stagger,
+		DisableKeepAlive:     *disableKeepAlive,
+		DisableDNSCache:      *noDNSCache,
+		UnixSocket:           *unixSocket,
+		IPVersion:            *ipVersion,
+		Cookies:              *cookies,
+		Proxy:                proxyURL,
+		ProxyFromEnvironment: *respectProxyEnv,
+		Insecure:             *insecure,
+		TLSMinVersion:        minVersion,
+		TLSMaxVersion:        maxVersion,
+		CipherSuites:         cipherSuites,
+		Certificates:         certificates,
+		RootCAs:              rootCAs,
+		MaxRedirects:         *maxRedirects,
+		RampUp:               *rampUp,
+		HTTPVersion:          httpVersion,
+		ExpectBody:           *expectBody,
+		ExpectRegex:          bodyRegex,
+		ExpectJSON:           *expectJSON,
+		JSONSchema:           jsonSchema,
+		SkipBody:             *noBodyRead,
+		Buckets:              bucketBounds,
+	}
+
+	if config.IPVersion != 0 {
+		if err := checkIPVersion(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(lookupExitCode(err))
+		}
+	}
+
+	if *dryRun {
+		if err := printDryRun(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(lookupExitCode(err))
+		}
+		return
+	}
+
+	// Keep stdout clean for machine-readable reports.
+	banner := os.Stdout
+	for _, output := range outputs {
+		if output.file == os.Stdout && output.format != "text" {
+			banner = os.Stderr
+		}
+	}
+
+	if !*quiet {
+		if len(steps) > 0 {
+			fmt.Fprintf(banner, "Starting load test for a %d-step session\n", len(steps))
+		} else if *mix != "" {
+			fmt.Fprintf(banner, "Starting load test for %s with method mix %s\n", *targetURL, *mix)
+		} else if len(endpoints) > 0 {
+			fmt.Fprintf(banner, "Starting load test for %d endpoints\n", len(endpoints))
+		} else if len(urls) == 1 {
+			fmt.Fprintf(banner, "Starting load test for %s\n", urls[0])
+		} else {
+			fmt.Fprintf(banner, "Starting load test for %d URLs\n", len(urls))
+		}
+		printLoadProfile(banner, config)
+		fmt.Fprintln(banner)
+	}
+
+	var csvWriter *csv.Writer
+	if *csvFile != "" {
+		file, err := os.Create(*csvFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not create CSV file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		defer file.Close()
+		csvWriter = csv.NewWriter(file)
+	}
+
+	var seriesOutput *os.File
+	if *timeSeriesFile != "" {
+		seriesOutput, err = os.Create(*timeSeriesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not create time series file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		defer seriesOutput.Close()
+	}
+
+	var progress io.Writer
+	if !*quiet && !*verbose && !*verboseHeaders && *interval == 0 && *every == 0 && isTerminal(os.Stdout) {
+		progress = os.Stderr
+	}
 
-			resultChan <- result
-		}()
+	var verboseLog io.Writer
+	if *verbose || *verboseHeaders {
+		verboseLog = os.Stderr
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	go func() {
-		wg.Wait()
-		close(resultChan)
+		// Restore default signal handling so a second Ctrl+C exits immediately.
+		<-ctx.Done()
+		stop()
 	}()
 
-	report := Report{
-		TotalRequests: totalRequests,
-		StatusCodes:   make(map[int]int),
-		MinTime:       time.Hour,
+	config.Progress = progress
+	config.CSV = csvWriter
+	config.Verbose = verboseLog
+	config.Interim = banner
+
+	limits := thresholds{
+		maxErrorRate:  *maxErrorRate,
+		maxP95:        *maxP95,
+		minRPS:        *minRPS,
+		rateTolerance: *rateTolerance,
+		strictRate:    *strictRate,
+	}
+
+	if *every > 0 {
+		if err := monitor(ctx, config, *every, outputs, limits, *prometheusFile, *noColor); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		return
+	}
+
+	report, err := loadtest.Run(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, loadtest.ErrPreflight) {
+			fmt.Fprintln(os.Stderr, "The target cannot be reached; use -no-preflight to run the test anyway")
+		}
+		os.Exit(lookupExitCode(err))
+	}
+	report.Version = version
+
+	if csvWriter != nil {
+		if err := csvWriter.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not write CSV file: %v\n", err)
+		}
 	}
+	for _, output := range outputs {
+		style := palette{
+			enabled: output.file == os.Stdout && !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+		}
+		printReport(output.file, report, output.format, style, limits)
+	}
+
+	if *prometheusFile != "" {
+		if err := writePrometheusFile(*prometheusFile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not write Prometheus metrics: %v\n", err)
+		}
+	}
+	if seriesOutput != nil {
+		if err := writeTimeSeries(seriesOutput, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not write time series file: %v\n", err)
+		}
+	}
+
+	failures := limits.check(report)
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "Threshold failed: %s\n", failure)
+	}
+
+	if *baselinePath != "" {
+		style := palette{enabled: banner == os.Stdout && !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)}
+		regressions := compareBaseline(banner, baseline, report, *regressionTolerance, style)
+		for _, regression := range regressions {
+			fmt.Fprintf(os.Stderr, "Regression: %s\n", regression)
+		}
+		failures = append(failures, regressions...)
+	}
+
+	if *metricsPort > 0 {
+		serveCtx, stopServing := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://localhost:%d/metrics (press Ctrl+C to exit)\n", *metricsPort)
+		err := serveMetrics(serveCtx, fmt.Sprintf(":%d", *metricsPort), report)
+		stopServing()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not serve metrics: %v\n", err)
+			os.Exit(exitInternal)
+		}
+	}
+
+	if len(failures) > 0 || report.StoppedOnError {
+		os.Exit(exitFailed)
+	}
+}
+
+// readURLs reads the URLs listed one per line in the file at path, or on
+// stdin if path is "-".
+func readURLs(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line, err = expandEnv(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		urls = append(urls, line)
+	}
+	if len(urls) == 0 && path == "-" {
+		return nil, errors.New("no URLs found")
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no URLs found in %s", path)
+	}
+	return urls, nil
+}
 
-	var totalTime time.Duration
+// templateAction matches the {{...}} actions of URL templates, which are
+// replaced by a placeholder before the URL is validated.
+var templateAction = regexp.MustCompile(`{{.*?}}`)
 
-	for result := range resultChan {
-		if result.Error != nil {
-			report.FailedRequests++
+// readJSONLines reads the non-empty lines of a JSON lines file, each of
+// which must be a valid JSON value.
+func readJSONLines(path string) ([][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines [][]byte
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
 			continue
 		}
+		if !json.Valid(line) {
+			return nil, fmt.Errorf("line %d of %s is not valid JSON", i+1, path)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no bodies found in %s", path)
+	}
+	return lines, nil
+}
 
-		report.StatusCodes[result.StatusCode]++
-		totalTime += result.Duration
+// validateURL rejects URLs that are not absolute http or https URLs with a
+// host, which would otherwise fail every request identically.
+func validateURL(raw string) error {
+	u, err := url.Parse(templateAction.ReplaceAllString(raw, "x"))
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme in %q, expected http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+	return nil
+}
 
-		if result.StatusCode == http.StatusOK {
-			report.SuccessfulRequests++
+func parseStages(value string) ([]loadtest.Stage, error) {
+	var stages []loadtest.Stage
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid stage %q, expected duration:rate[:concurrency]", part)
 		}
+		var stage loadtest.Stage
+		var err error
+		stage.Duration, err = time.ParseDuration(fields[0])
+		if err != nil || stage.Duration <= 0 {
+			return nil, fmt.Errorf("invalid stage duration in %q", part)
+		}
+		stage.Rate, err = strconv.ParseFloat(fields[1], 64)
+		if err != nil || stage.Rate < 0 {
+			return nil, fmt.Errorf("invalid stage rate in %q", part)
+		}
+		if len(fields) == 3 {
+			stage.Concurrency, err = strconv.Atoi(fields[2])
+			if err != nil || stage.Concurrency <= 0 {
+				return nil, fmt.Errorf("invalid stage concurrency in %q", part)
+			}
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
 
-		if result.Duration < report.MinTime {
-			report.MinTime = result.Duration
+func parseSpike(value string) (*loadtest.Spike, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid spike %q, expected peak:ramp-up:hold:ramp-down", value)
+	}
+	peak, err := strconv.Atoi(fields[0])
+	if err != nil || peak <= 0 {
+		return nil, fmt.Errorf("invalid spike peak in %q", value)
+	}
+	spike := &loadtest.Spike{Peak: peak}
+	for i, d := range []*time.Duration{&spike.RampUp, &spike.Hold, &spike.RampDown} {
+		*d, err = time.ParseDuration(fields[i+1])
+		if err != nil || *d < 0 {
+			return nil, fmt.Errorf("invalid spike duration in %q", value)
 		}
-		if result.Duration > report.MaxTime {
-			report.MaxTime = result.Duration
+	}
+	if spike.RampUp+spike.Hold+spike.RampDown <= 0 {
+		return nil, fmt.Errorf("spike %q has no duration", value)
+	}
+	return spike, nil
+}
+
+// parseMix parses a -mix value such as "GET:80,POST:20" into one endpoint
+// per method requesting rawURL, named after the method. POST, PUT and
+// PATCH send body; the other methods send none.
+func parseMix(value, rawURL string, body []byte) ([]loadtest.Endpoint, error) {
+	var endpoints []loadtest.Endpoint
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		method, weight, ok := strings.Cut(strings.TrimSpace(part), ":")
+		method = strings.ToUpper(method)
+		if !ok || !validMethods[method] {
+			return nil, fmt.Errorf("invalid mix entry %q, expected METHOD:weight", part)
+		}
+		if seen[method] {
+			return nil, fmt.Errorf("method %s appears twice in the mix", method)
+		}
+		seen[method] = true
+		w, err := strconv.Atoi(weight)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid mix weight in %q", part)
+		}
+		endpoint := loadtest.Endpoint{Name: method, Method: method, URL: rawURL, Weight: w}
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+			endpoint.Body = body
 		}
+		endpoints = append(endpoints, endpoint)
 	}
+	return endpoints, nil
+}
 
-	report.TotalDuration = time.Since(startTime)
-	if totalRequests-report.FailedRequests > 0 {
-		report.AverageTime = totalTime / time.Duration(totalRequests-report.FailedRequests)
+func parseBuckets(value string) ([]time.Duration, error) {
+	var bounds []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		bound, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || bound <= 0 {
+			return nil, fmt.Errorf("invalid histogram bucket %q", part)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("histogram buckets must be in increasing order")
+		}
+		bounds = append(bounds, bound)
 	}
+	return bounds, nil
+}
 
-	return report
+// readRandomHeaders parses the -H-random values, reading the candidate
+// values of each header from its @file, one per line.
+func readRandomHeaders(values []string) (http.Header, error) {
+	headers, err := parseHeaders(values)
+	if err != nil {
+		return nil, err
+	}
+	for name, paths := range headers {
+		var candidates []string
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "@") {
+				return nil, fmt.Errorf("invalid random header %s, expected \"Name: @file\"", name)
+			}
+			data, err := os.ReadFile(path[1:])
+			if err != nil {
+				return nil, fmt.Errorf("could not read values for header %s: %w", name, err)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					candidates = append(candidates, line)
+				}
+			}
+			if len(candidates) == 0 {
+				return nil, fmt.Errorf("no values for header %s found in %s", name, path[1:])
+			}
+		}
+		headers[name] = candidates
+	}
+	return headers, nil
 }
 
-func printReport(report Report) {
-	fmt.Println("=== Load Test Report ===")
-	fmt.Printf("Total time: %v\n", report.TotalDuration)
-	fmt.Printf("Total requests: %d\n", report.TotalRequests)
-	fmt.Printf("Successful requests (HTTP 200): %d\n", report.SuccessfulRequests)
-	fmt.Printf("Failed requests: %d\n", report.FailedRequests)
-	fmt.Printf("Requests per second: %.2f\n", float64(report.TotalRequests)/report.TotalDuration.Seconds())
-	fmt.Printf("Average response time: %v\n", report.AverageTime)
-	fmt.Printf("Min response time: %v\n", report.MinTime)
-	fmt.Printf("Max response time: %v\n", report.MaxTime)
-
-	fmt.Println("\nStatus code distribution:")
-	for code, count := range report.StatusCodes {
-		fmt.Printf("  [%d]: %d responses\n", code, count)
+func parseHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, value := range values {
+		name, val, ok := strings.Cut(value, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", value)
+		}
+		headers.Add(name, strings.TrimSpace(val))
 	}
+	return headers, nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
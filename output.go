@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var validFormats = map[string]bool{
+	"text":       true,
+	"json":       true,
+	"prometheus": true,
+	"summary":    true,
+}
+
+// reportOutput is a destination for the report, given to -output as
+// "format" for stdout or "format=path" for a file.
+type reportOutput struct {
+	format string
+	file   *os.File
+}
+
+// openOutputs parses the -output values, creating any files so that an
+// unwritable path fails before the test starts. Without values the text
+// report goes to stdout.
+func openOutputs(values []string) ([]reportOutput, error) {
+	if len(values) == 0 {
+		values = []string{"text"}
+	}
+
+	var outputs []reportOutput
+	for _, value := range values {
+		format, path, hasPath := strings.Cut(value, "=")
+		if !validFormats[format] {
+			closeOutputs(outputs)
+			return nil, fmt.Errorf("unsupported output format %q", format)
+		}
+		if !hasPath || path == "-" {
+			outputs = append(outputs, reportOutput{format: format, file: os.Stdout})
+			continue
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, fmt.Errorf("could not create output file: %w", err)
+		}
+		outputs = append(outputs, reportOutput{format: format, file: file})
+	}
+	return outputs, nil
+}
+
+func closeOutputs(outputs []reportOutput) {
+	for _, output := range outputs {
+		if output.file != os.Stdout {
+			output.file.Close()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// writePrometheus writes report in the Prometheus text exposition format.
+func writePrometheus(w io.Writer, report loadtest.Report) {
+	fmt.Fprintln(w, "# HELP loadtest_requests_total Requests sent, including failed ones.")
+	fmt.Fprintln(w, "# TYPE loadtest_requests_total counter")
+	fmt.Fprintf(w, "loadtest_requests_total %d\n", report.TotalRequests)
+
+	fmt.Fprintln(w, "# HELP loadtest_successful_requests_total Requests that received a success status code.")
+	fmt.Fprintln(w, "# TYPE loadtest_successful_requests_total counter")
+	fmt.Fprintf(w, "loadtest_successful_requests_total %d\n", report.SuccessfulRequests)
+
+	fmt.Fprintln(w, "# HELP loadtest_errors_total Requests that failed without a response, by category.")
+	fmt.Fprintln(w, "# TYPE loadtest_errors_total counter")
+	categories := make([]string, 0, len(report.ErrorCategories))
+	for category := range report.ErrorCategories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(w, "loadtest_errors_total{category=%q} %d\n", category, report.ErrorCategories[category])
+	}
+
+	fmt.Fprintln(w, "# HELP loadtest_responses_total Responses received, by status code.")
+	fmt.Fprintln(w, "# TYPE loadtest_responses_total counter")
+	codes := make([]int, 0, len(report.StatusCodes))
+	for code := range report.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "loadtest_responses_total{code=\"%d\"} %d\n", code, report.StatusCodes[code])
+	}
+
+	fmt.Fprintln(w, "# HELP loadtest_request_duration_seconds Response times of completed requests.")
+	fmt.Fprintln(w, "# TYPE loadtest_request_duration_seconds histogram")
+	count := 0
+	for _, bucket := range report.Histogram {
+		count += bucket.Count
+		le := "+Inf"
+		if bucket.Max > 0 {
+			le = strconv.FormatFloat(bucket.Max.Seconds(), 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "loadtest_request_duration_seconds_bucket{le=%q} %d\n", le, count)
+	}
+	sum := report.AverageTime * time.Duration(count)
+	fmt.Fprintf(w, "loadtest_request_duration_seconds_sum %g\n", sum.Seconds())
+	fmt.Fprintf(w, "loadtest_request_duration_seconds_count %d\n", count)
+
+	fmt.Fprintln(w, "# HELP loadtest_requests_per_second Average request rate over the test.")
+	fmt.Fprintln(w, "# TYPE loadtest_requests_per_second gauge")
+	fmt.Fprintf(w, "loadtest_requests_per_second %g\n", report.RequestsPerSecond)
+
+	fmt.Fprintln(w, "# HELP loadtest_duration_seconds Total duration of the test.")
+	fmt.Fprintln(w, "# TYPE loadtest_duration_seconds gauge")
+	fmt.Fprintf(w, "loadtest_duration_seconds %g\n", report.TotalDuration.Seconds())
+}
+
+func writePrometheusFile(path string, report loadtest.Report) error {
+	if path == "-" {
+		writePrometheus(os.Stdout, report)
+		return nil
+	}
+	var buf bytes.Buffer
+	writePrometheus(&buf, report)
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// serveMetrics serves report on /metrics at addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string, report loadtest.Report) error {
+	var buf bytes.Buffer
+	writePrometheus(&buf, report)
+	metrics := buf.Bytes()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(metrics)
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// printReport writes report to w in the given format. In text mode, style
+// colours the success and failure counts, status codes and, when limits
+// sets a p95 threshold, the p95 as it nears or exceeds it.
+func printReport(w io.Writer, report loadtest.Report, format string, style palette, limits thresholds) {
+	if format == "prometheus" {
+		writePrometheus(w, report)
+		return
+	}
+	if format == "summary" {
+		printSummary(w, report)
+		return
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Could not encode report: %v\n", err)
+		}
+		return
+	}
+
+	fmt.Fprintln(w, "=== Load Test Report ===")
+	if report.Label != "" {
+		fmt.Fprintf(w, "Label: %s\n", report.Label)
+	}
+	if len(report.Tags) > 0 {
+		names := make([]string, 0, len(report.Tags))
+		for name := range report.Tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tags := make([]string, len(names))
+		for i, name := range names {
+			tags[i] = name + "=" + report.Tags[name]
+		}
+		fmt.Fprintf(w, "Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if report.Interrupted {
+		fmt.Fprintln(w, style.yellow("Test interrupted; showing partial results"))
+	}
+	if report.StoppedOnError {
+		fmt.Fprintln(w, style.red("Stopped at the first failed request; showing partial results"))
+	}
+	if report.DeadlineExceeded {
+		fmt.Fprintln(w, style.yellow("Maximum duration reached; showing partial results"))
+	}
+	if report.WarmupRequests > 0 {
+		fmt.Fprintf(w, "Warmup requests (excluded): %d\n", report.WarmupRequests)
+	}
+	fmt.Fprintf(w, "Total time: %v\n", report.TotalDuration)
+	fmt.Fprintf(w, "Measured window: %v\n", report.MeasuredDuration)
+	fmt.Fprintf(w, "Total requests: %d\n", report.TotalRequests)
+	successful := fmt.Sprintf("Successful requests: %d (%.2f%%)", report.SuccessfulRequests, report.SuccessRate)
+	if report.SuccessfulRequests == report.TotalRequests {
+		fmt.Fprintln(w, style.green(successful))
+	} else {
+		fmt.Fprintln(w, style.yellow(successful))
+	}
+	failed := fmt.Sprintf("Failed requests: %d", report.FailedRequests)
+	if report.FailedRequests > 0 {
+		fmt.Fprintln(w, style.red(failed))
+	} else {
+		fmt.Fprintln(w, failed)
+	}
+	errorRate := fmt.Sprintf("Error rate: %.2f%%", report.ErrorRate)
+	if report.ErrorRate > 0 {
+		fmt.Fprintln(w, style.red(errorRate))
+	} else {
+		fmt.Fprintln(w, errorRate)
+	}
+	if len(report.ErrorCategories) > 0 {
+		categories := make([]string, 0, len(report.ErrorCategories))
+		for category := range report.ErrorCategories {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			fmt.Fprintln(w, style.red(fmt.Sprintf("  %s: %d", category, report.ErrorCategories[category])))
+		}
+	}
+	if report.Retries > 0 {
+		fmt.Fprintf(w, "Retries: %d across %d requests\n", report.Retries, report.RetriedRequests)
+	}
+	if report.RateLimitedRequests > 0 {
+		fmt.Fprintln(w, style.yellow(fmt.Sprintf("Rate-limited requests (429, or 503 with Retry-After): %d", report.RateLimitedRequests)))
+	}
+	if report.SLO > 0 {
+		slo := fmt.Sprintf("SLO (%v): %.2f%% compliant, %d violations (%.2f%%)", report.SLO, report.SLOCompliance, report.SLOViolations, report.SLOViolationRate)
+		if report.SLOViolations > 0 {
+			fmt.Fprintln(w, style.yellow(slo))
+		} else {
+			fmt.Fprintln(w, style.green(slo))
+		}
+	}
+	if report.ValidationFailures > 0 {
+		fmt.Fprintln(w, style.red(fmt.Sprintf("Body validation failures: %d", report.ValidationFailures)))
+		if report.SchemaFailures > 0 {
+			fmt.Fprintln(w, style.red(fmt.Sprintf("  JSON schema mismatches: %d", report.SchemaFailures)))
+		}
+	}
+	fmt.Fprintf(w, "Requests per second: %.2f\n", report.RequestsPerSecond)
+	if limits.rateShortfall(report) {
+		shortfall := (1 - report.RequestsPerSecond/report.RequestedRate) * 100
+		fmt.Fprintln(w, style.yellow(fmt.Sprintf("Warning: the achieved rate is %.1f%% below the requested %g req/s; the target could not keep up at this concurrency", shortfall, report.RequestedRate)))
+	}
+	fmt.Fprintf(w, "Concurrency: %.1f achieved on average, %d requested\n", report.AchievedConcurrency, report.RequestedConcurrency)
+	if report.Saturated {
+		fmt.Fprintln(w, style.yellow("Warning: the achieved concurrency is far below the requested level; the load generator itself may be the bottleneck (check CPU, file descriptor limits and connection setup)"))
+	}
+	if report.MaxInFlight > 0 {
+		fmt.Fprintf(w, "Max in flight: %d\n", report.MaxInFlight)
+		fmt.Fprintf(w, "Queue time: avg %v, max %v\n", report.AverageQueueTime, report.MaxQueueTime)
+	}
+	if report.DroppedRequests > 0 {
+		fmt.Fprintln(w, style.yellow(fmt.Sprintf("Dropped requests (max in flight reached): %d", report.DroppedRequests)))
+	}
+	fmt.Fprintf(w, "Total bytes received: %d (%d on the wire)\n", report.TotalBytes, report.TotalWireBytes)
+	fmt.Fprintf(w, "Throughput: %.2f MB/s (%.2f MB/s on the wire)\n", report.BytesPerSecond/1e6, report.WireBytesPerSecond/1e6)
+	if report.TotalRequests == report.FailedRequests {
+		fmt.Fprintln(w, "Average response time: N/A")
+		fmt.Fprintln(w, "Median response time: N/A")
+		fmt.Fprintln(w, "Standard deviation: N/A")
+		fmt.Fprintln(w, "Min response time: N/A")
+		fmt.Fprintln(w, "Max response time: N/A")
+	} else {
+		fmt.Fprintf(w, "Average response time: %v\n", report.AverageTime)
+		fmt.Fprintf(w, "Median response time: %v\n", report.Median)
+		fmt.Fprintf(w, "Standard deviation: %v\n", report.StdDev)
+		fmt.Fprintf(w, "Min response time: %v\n", report.MinTime)
+		fmt.Fprintf(w, "Max response time: %v\n", report.MaxTime)
+	}
+
+	if report.AverageTTFB > 0 {
+		fmt.Fprintln(w, "\nConnection timing (average):")
+		// Phases no request went through, such as DNS lookups served by the
+		// cache or TLS on plain HTTP, are left out.
+		if report.AverageDNS > 0 {
+			fmt.Fprintf(w, "  DNS lookup: %v\n", report.AverageDNS)
+		}
+		if report.AverageConnect > 0 {
+			fmt.Fprintf(w, "  TCP connect: %v\n", report.AverageConnect)
+		}
+		if report.AverageTLS > 0 {
+			fmt.Fprintf(w, "  TLS handshake: %v\n", report.AverageTLS)
+		}
+		fmt.Fprintf(w, "  Time to first byte: %v\n", report.AverageTTFB)
+	}
+	if report.ReusedConnections+report.NewConnections > 0 {
+		fmt.Fprintf(w, "\nConnection reuse: %.2f%% (%d reused, %d new)\n",
+			report.ConnectionReuseRate, report.ReusedConnections, report.NewConnections)
+	}
+
+	fmt.Fprintln(w, "\nResponse time percentiles:")
+	fmt.Fprintf(w, "  p50: %v\n", report.P50)
+	fmt.Fprintf(w, "  p90: %v\n", report.P90)
+	p95 := fmt.Sprintf("  p95: %v", report.P95)
+	switch {
+	case limits.maxP95 <= 0:
+		fmt.Fprintln(w, p95)
+	case report.P95 > limits.maxP95:
+		fmt.Fprintln(w, style.red(p95))
+	case report.P95 > limits.maxP95*8/10:
+		fmt.Fprintln(w, style.yellow(p95))
+	default:
+		fmt.Fprintln(w, style.green(p95))
+	}
+	fmt.Fprintf(w, "  p99: %v\n", report.P99)
+
+	printHistogram(w, report.Histogram)
+
+	fmt.Fprintln(w, "\nStatus code distribution:")
+	codes := make([]int, 0, len(report.StatusCodes))
+	for code := range report.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		latency := report.StatusLatencies[code]
+		line := fmt.Sprintf("  [%d]: %d responses (min %v, avg %v, max %v)",
+			code, report.StatusCodes[code], latency.MinTime, latency.AverageTime, latency.MaxTime)
+		switch {
+		case code >= 500:
+			line = style.red(line)
+		case code >= 400:
+			line = style.yellow(line)
+		case code >= 200 && code < 300:
+			line = style.green(line)
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	headerNames := make([]string, 0, len(report.HeaderValues))
+	for name := range report.HeaderValues {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		counts := report.HeaderValues[name]
+		values := make([]string, 0, len(counts))
+		total := 0
+		for value, count := range counts {
+			values = append(values, value)
+			total += count
+		}
+		sort.Strings(values)
+
+		fmt.Fprintf(w, "\n%s distribution:\n", name)
+		for _, value := range values {
+			fmt.Fprintf(w, "  %s: %d responses (%.2f%%)\n", value, counts[value], float64(counts[value])/float64(total)*100)
+		}
+	}
+
+	if len(report.Protocols) > 0 {
+		protocols := make([]string, 0, len(report.Protocols))
+		for proto := range report.Protocols {
+			protocols = append(protocols, proto)
+		}
+		sort.Strings(protocols)
+
+		fmt.Fprintln(w, "\nProtocol distribution:")
+		for _, proto := range protocols {
+			fmt.Fprintf(w, "  %s: %d responses\n", proto, report.Protocols[proto])
+		}
+	}
+
+	if knee := report.KneePoint; knee != nil {
+		load := fmt.Sprintf("concurrency %d", knee.Concurrency)
+		if knee.Rate > 0 {
+			load = fmt.Sprintf("%g req/s, %s", knee.Rate, load)
+		}
+		if knee.Stage > 0 {
+			load = fmt.Sprintf("stage %d, %s", knee.Stage, load)
+		}
+		fmt.Fprintln(w, style.yellow(fmt.Sprintf("\nKnee point: error rate reached %.2f%% after %v (%s; %.2f req/s achieved)",
+			knee.ErrorRate, knee.Elapsed.Round(time.Millisecond), load, knee.RequestsPerSecond)))
+	}
+	if report.MaxSustainedRPS > 0 {
+		fmt.Fprintf(w, "\nMax sustained requests per second: %.2f\n", report.MaxSustainedRPS)
+	}
+
+	if adaptive := report.Adaptive; adaptive != nil {
+		fmt.Fprintf(w, "\nAdaptive concurrency (target p95 %v):\n", adaptive.TargetP95)
+		for _, interval := range adaptive.Intervals {
+			line := fmt.Sprintf("  [%v] concurrency %d: %.2f req/s, p95 %v, error rate %.2f%%",
+				interval.Elapsed.Round(time.Second), interval.Concurrency, interval.RequestsPerSecond, interval.P95, interval.ErrorRate)
+			if interval.Degraded {
+				line = style.yellow(line + " (backing off)")
+			}
+			fmt.Fprintln(w, line)
+		}
+		if adaptive.BestConcurrency > 0 {
+			fmt.Fprintln(w, style.green(fmt.Sprintf("  Peak sustainable load: concurrency %d, %.2f req/s with p95 %v",
+				adaptive.BestConcurrency, adaptive.BestRPS, adaptive.BestP95)))
+			if adaptive.BestConcurrency == report.RequestedConcurrency {
+				fmt.Fprintln(w, "  The target held at the maximum concurrency; raise -concurrency to search further")
+			}
+		} else {
+			fmt.Fprintln(w, style.red("  No interval stayed within the target"))
+		}
+	}
+
+	if len(report.Stages) > 0 {
+		fmt.Fprintln(w, "\nPer-stage breakdown:")
+		for i, stage := range report.Stages {
+			rate := "unlimited rate"
+			if stage.Rate > 0 {
+				rate = fmt.Sprintf("%g req/s", stage.Rate)
+			}
+			fmt.Fprintf(w, "  Stage %d: %v, %s, concurrency %d\n", i+1, stage.Duration, rate, stage.Concurrency)
+			fmt.Fprintf(w, "    Requests: %d, Successful: %d, Failed: %d, RPS: %.2f, Average: %v, p95: %v\n",
+				stage.Requests, stage.SuccessfulRequests, stage.FailedRequests,
+				stage.RequestsPerSecond, stage.AverageTime, stage.P95)
+		}
+	}
+
+	if len(report.Runs) > 0 {
+		fmt.Fprintln(w, "\nPer-run breakdown:")
+		for i, run := range report.Runs {
+			fmt.Fprintf(w, "  Run %d: Requests: %d, Successful: %d, Failed: %d, RPS: %.2f, Average: %v, p95: %v\n",
+				i+1, run.Requests, run.SuccessfulRequests, run.FailedRequests,
+				run.RequestsPerSecond, run.AverageTime, run.P95)
+		}
+		if spread := report.RunSpread; spread != nil {
+			fmt.Fprintln(w, "Across runs (mean ± standard deviation):")
+			fmt.Fprintf(w, "  RPS: %.2f ± %.2f\n", spread.RequestsPerSecondMean, spread.RequestsPerSecondStdDev)
+			fmt.Fprintf(w, "  Average: %v ± %v\n", spread.AverageTimeMean, spread.AverageTimeStdDev)
+			fmt.Fprintf(w, "  p95: %v ± %v\n", spread.P95Mean, spread.P95StdDev)
+			fmt.Fprintf(w, "  Error rate: %.2f%% ± %.2f%%\n", spread.ErrorRateMean, spread.ErrorRateStdDev)
+		}
+	}
+
+	if len(report.SlowestRequests) > 0 {
+		fmt.Fprintf(w, "\nSlowest %d requests:\n", len(report.SlowestRequests))
+		for _, request := range report.SlowestRequests {
+			outcome := strconv.Itoa(request.StatusCode)
+			if request.Error != "" {
+				outcome = style.red(request.Error)
+			}
+			fmt.Fprintf(w, "  %v  %s %s  %s\n", request.Duration, request.Method, request.URL, outcome)
+		}
+	}
+	if len(report.URLStats) > 0 {
+		urls := make([]string, 0, len(report.URLStats))
+		for url := range report.URLStats {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+
+		fmt.Fprintln(w, "\nPer-endpoint breakdown:")
+		for _, url := range urls {
+			stats := report.URLStats[url]
+			fmt.Fprintf(w, "  %s\n", url)
+			fmt.Fprintf(w, "    Requests: %d, Successful: %d, Failed: %d, Average: %v\n",
+				stats.Requests, stats.SuccessfulRequests, stats.FailedRequests, stats.AverageTime)
+		}
+	}
+}
+
+// printSummary writes the main figures of report on a single line, for
+// scanning CI logs. err counts every request that was not ok, like
+// err_rate.
+func printSummary(w io.Writer, report loadtest.Report) {
+	fmt.Fprintf(w, "reqs=%d ok=%d err=%d err_rate=%.2f%% rps=%.1f p95=%s avg=%s",
+		report.TotalRequests, report.SuccessfulRequests, report.TotalRequests-report.SuccessfulRequests, report.ErrorRate, report.RequestsPerSecond,
+		report.P95.Round(time.Microsecond), report.AverageTime.Round(time.Microsecond))
+	if report.Interrupted || report.DeadlineExceeded || report.StoppedOnError {
+		fmt.Fprint(w, " partial=true")
+	}
+	fmt.Fprintln(w)
+}
+
+func printHistogram(w io.Writer, buckets []loadtest.HistogramBucket) {
+	const barWidth = 40
+
+	maxCount := 0
+	for _, bucket := range buckets {
+		if bucket.Count > maxCount {
+			maxCount = bucket.Count
+		}
+	}
+	if maxCount == 0 {
+		return
+	}
+
+	labels := make([]string, len(buckets))
+	labelWidth := 0
+	for i, bucket := range buckets {
+		switch {
+		case i == 0:
+			labels[i] = fmt.Sprintf("< %v", bucket.Max)
+		case bucket.Max == 0:
+			labels[i] = fmt.Sprintf(">= %v", bucket.Min)
+		default:
+			labels[i] = fmt.Sprintf("%v - %v", bucket.Min, bucket.Max)
+		}
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+	}
+
+	fmt.Fprintln(w, "\nResponse time histogram:")
+	for i, bucket := range buckets {
+		bar := strings.Repeat("#", bucket.Count*barWidth/maxCount)
+		line := fmt.Sprintf("  %-*s %6d %s", labelWidth, labels[i], bucket.Count, bar)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
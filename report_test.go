@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+func TestPrintReportWithoutResponses(t *testing.T) {
+	report := loadtest.Report{
+		TotalRequests:   10,
+		FailedRequests:  10,
+		ErrorRate:       100,
+		ErrorCategories: map[string]int{"connection refused": 10},
+	}
+
+	var buf bytes.Buffer
+	printReport(&buf, report, "text", palette{}, thresholds{})
+
+	for _, want := range []string{"Min response time: N/A", "Max response time: N/A", "Average response time: N/A"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "1h0m0s") {
+		t.Errorf("report shows a response time of 1h:\n%s", buf.String())
+	}
+}
+
+func TestPrintSummaryCountsBadStatusesAsErrors(t *testing.T) {
+	report := loadtest.Report{
+		TotalRequests:      10,
+		SuccessfulRequests: 6,
+		FailedRequests:     1,
+		ErrorRate:          40,
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, report)
+
+	if want := "reqs=10 ok=6 err=4 err_rate=40.00%"; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("summary = %q, want it to start with %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// scenarioFile is the JSON format read by -scenario.
+type scenarioFile struct {
+	Endpoints []struct {
+		Name     string            `json:"name"`
+		Method   string            `json:"method"`
+		URL      string            `json:"url"`
+		Body     string            `json:"body"`
+		BodyFile string            `json:"body_file"`
+		Headers  map[string]string `json:"headers"`
+		Weight   int               `json:"weight"`
+	} `json:"endpoints"`
+}
+
+// readScenario reads the weighted endpoints of a scenario file. Endpoint URLs
+// starting with "/" are relative to baseURL, and endpoints without a method
+// use defaultMethod.
+func readScenario(path, baseURL, defaultMethod string) ([]loadtest.Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario scenarioFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario file %s: %w", path, err)
+	}
+	if len(scenario.Endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints found in %s", path)
+	}
+
+	endpoints := make([]loadtest.Endpoint, len(scenario.Endpoints))
+	for i, e := range scenario.Endpoints {
+		if err := expandEnvValues(&e.URL, &e.Body); err != nil {
+			return nil, fmt.Errorf("endpoint %d: %w", i+1, err)
+		}
+		url, err := resolveURL(e.URL, baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %d: %w", i+1, err)
+		}
+
+		method := strings.ToUpper(e.Method)
+		if method == "" {
+			method = defaultMethod
+		}
+		if !validMethods[method] {
+			return nil, fmt.Errorf("endpoint %q has unsupported HTTP method %q", url, e.Method)
+		}
+
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("endpoint %q has a negative weight", url)
+		}
+
+		if e.Body != "" && e.BodyFile != "" {
+			return nil, fmt.Errorf("endpoint %q has both body and body_file", url)
+		}
+		body := []byte(e.Body)
+		if e.BodyFile != "" {
+			body, err = os.ReadFile(e.BodyFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read body file: %w", err)
+			}
+		}
+
+		headers := make(http.Header)
+		for name, value := range e.Headers {
+			if value, err = expandEnv(value); err != nil {
+				return nil, fmt.Errorf("endpoint %q: %w", url, err)
+			}
+			headers.Set(name, value)
+		}
+
+		endpoints[i] = loadtest.Endpoint{
+			Name:    e.Name,
+			Method:  method,
+			URL:     url,
+			Body:    body,
+			Headers: headers,
+			Weight:  e.Weight,
+		}
+	}
+	return endpoints, nil
+}
+
+// resolveURL joins URLs starting with "/" to baseURL.
+func resolveURL(url, baseURL string) (string, error) {
+	if url == "" {
+		return "", errors.New("missing url")
+	}
+	if !strings.HasPrefix(url, "/") {
+		return url, nil
+	}
+	if baseURL == "" {
+		return "", fmt.Errorf("%q is a relative url but -url is not set", url)
+	}
+	return strings.TrimSuffix(baseURL, "/") + url, nil
+}
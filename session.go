@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// sessionFile is the JSON format read by -session.
+type sessionFile struct {
+	Steps []struct {
+		Name    string            `json:"name"`
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Body    string            `json:"body"`
+		Headers map[string]string `json:"headers"`
+		Extract []struct {
+			Name  string `json:"name"`
+			Regex string `json:"regex"`
+			JSON  string `json:"json"`
+		} `json:"extract"`
+	} `json:"steps"`
+}
+
+// readSession reads the ordered steps of a session file. Step URLs starting
+// with "/" are relative to baseURL, and steps without a method use
+// defaultMethod.
+func readSession(path, baseURL, defaultMethod string) ([]loadtest.Step, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var session sessionFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&session); err != nil {
+		return nil, fmt.Errorf("invalid session file %s: %w", path, err)
+	}
+	if len(session.Steps) == 0 {
+		return nil, fmt.Errorf("no steps found in %s", path)
+	}
+
+	steps := make([]loadtest.Step, len(session.Steps))
+	for i, s := range session.Steps {
+		if err := expandEnvValues(&s.URL, &s.Body); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		url, err := resolveURL(s.URL, baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+
+		method := strings.ToUpper(s.Method)
+		if method == "" {
+			method = defaultMethod
+		}
+		if !validMethods[method] {
+			return nil, fmt.Errorf("step %d has unsupported HTTP method %q", i+1, s.Method)
+		}
+
+		headers := make(http.Header)
+		for name, value := range s.Headers {
+			if value, err = expandEnv(value); err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			headers.Set(name, value)
+		}
+
+		step := loadtest.Step{
+			Name:    s.Name,
+			Method:  method,
+			URL:     url,
+			Body:    s.Body,
+			Headers: headers,
+		}
+		for _, e := range s.Extract {
+			if e.Name == "" || (e.Regex == "") == (e.JSON == "") {
+				return nil, fmt.Errorf("step %d: each extraction needs a name and exactly one of regex or json", i+1)
+			}
+			extract := loadtest.Extract{Name: e.Name, JSONPath: e.JSON}
+			if e.Regex != "" {
+				if extract.Regexp, err = regexp.Compile(e.Regex); err != nil {
+					return nil, fmt.Errorf("step %d: %w", i+1, err)
+				}
+			}
+			step.Extract = append(step.Extract, extract)
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
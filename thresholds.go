@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+type thresholds struct {
+	maxErrorRate float64
+	maxP95       time.Duration
+	minRPS       float64
+	// rateTolerance is the percentage by which the achieved rate may fall
+	// short of the requested one before it is reported, and strictRate
+	// makes that a failure.
+	rateTolerance float64
+	strictRate    bool
+}
+
+// rateShortfall reports whether the test fell short of its requested rate
+// by more than the tolerance.
+func (t thresholds) rateShortfall(report loadtest.Report) bool {
+	return report.RequestedRate > 0 && report.TotalRequests > 0 &&
+		report.RequestsPerSecond < report.RequestedRate*(1-t.rateTolerance/100)
+}
+
+// check returns a description of every threshold the report violates.
+func (t thresholds) check(report loadtest.Report) []string {
+	var failures []string
+
+	if t.maxErrorRate >= 0 && report.TotalRequests > 0 {
+		if report.ErrorRate > t.maxErrorRate {
+			failures = append(failures, fmt.Sprintf("error rate %.2f%% exceeds maximum of %.2f%%", report.ErrorRate, t.maxErrorRate))
+		}
+	}
+
+	if t.maxP95 > 0 && report.P95 > t.maxP95 {
+		failures = append(failures, fmt.Sprintf("p95 response time %v exceeds maximum of %v", report.P95, t.maxP95))
+	}
+
+	if t.minRPS > 0 && report.RequestsPerSecond < t.minRPS {
+		failures = append(failures, fmt.Sprintf("%.2f requests per second is below minimum of %.2f", report.RequestsPerSecond, t.minRPS))
+	}
+
+	if t.strictRate && t.rateShortfall(report) {
+		failures = append(failures, fmt.Sprintf("%.2f requests per second is more than %g%% below the requested rate of %g", report.RequestsPerSecond, t.rateTolerance, report.RequestedRate))
+	}
+
+	return failures
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/UmVitor/load-test-go/loadtest"
+)
+
+// writeTimeSeries writes the time series of report as CSV, one row per
+// interval, with times in seconds and milliseconds like the -csv file.
+func writeTimeSeries(w io.Writer, report loadtest.Report) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"elapsed_s", "requests", "errors", "requests_per_second", "average_ms", "p95_ms"})
+	for _, point := range report.TimeSeries {
+		writer.Write([]string{
+			strconv.FormatFloat(point.Elapsed.Seconds(), 'f', 3, 64),
+			strconv.Itoa(point.Requests),
+			strconv.Itoa(point.Errors),
+			strconv.FormatFloat(point.RequestsPerSecond, 'f', 2, 64),
+			strconv.FormatFloat(float64(point.AverageTime)/float64(time.Millisecond), 'f', 3, 64),
+			strconv.FormatFloat(float64(point.P95)/float64(time.Millisecond), 'f', 3, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}